@@ -11,17 +11,28 @@ import (
 	"bytes"
 	"encoding/json"
 	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/dmitry-epam/osdu-tutorials-go/quickstart/internal/redact"
 	"github.com/tidwall/gjson"
 	"golang.org/x/net/context"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
 )
 
 const API_BASE_URL = "<your API base url here>"
 
+// limits for the block size picked from the blob size, bytes
+const (
+	minBlockSize = 1 << 20
+	maxBlockSize = 32 << 20
+)
+
+// number of blocks downloaded at the same time, increase for bigger files
+const blobParallelism = 4
+
 /*
 	This function creates the file URI based on JSON response
 	received from Delivery API
@@ -64,23 +75,33 @@ func getBufFromBlob(remoteFileURL string) []byte {
 	blobURL := azblob.NewBlobURL(*u, azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{}))
 	//log.Printf("Blob URL: %s", blobURL)
 
-	// setting the properties for downloading a blob incl. the prgress function
-	options := azblob.DownloadFromBlobOptions{
-		BlockSize:   2048, // bytes, experiment depending on the file size
-		Parallelism: 1,    // start with 1 and increase if you have bigger files
-		Progress: func(bytesTransferred int64) {
-			log.Printf("Downloaded %s bytes", strconv.FormatInt(bytesTransferred, 10))
-		},
-	}
-
 	// first, we need to indentify the size of a blob to allocate buffer
-	props, err := blobURL.GetProperties(ctx, options.AccessConditions)
+	props, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{})
 	if err != nil {
 		log.Printf("Cannot read blob size: %s", err)
+		return nil
 	}
 	size := props.ContentLength()
 	log.Printf("Blob size is %s bytes", strconv.FormatInt(size, 10))
 
+	// setting the properties for downloading a blob incl. the progress function;
+	// blocks are sized from the blob so that each of the parallel requests
+	// downloads a few of them, instead of tens of thousands of tiny requests
+	blockSize := size / (blobParallelism * 4)
+	if blockSize < minBlockSize {
+		blockSize = minBlockSize
+	}
+	if blockSize > maxBlockSize {
+		blockSize = maxBlockSize
+	}
+	options := azblob.DownloadFromBlobOptions{
+		BlockSize:   blockSize,
+		Parallelism: blobParallelism,
+		Progress: func(bytesTransferred int64) {
+			log.Printf("Downloaded %s of %s bytes", strconv.FormatInt(bytesTransferred, 10), strconv.FormatInt(size, 10))
+		},
+	}
+
 	buf := make([]byte, size)
 
 	// next, we're reading the blob into in-memory buffer
@@ -97,6 +118,9 @@ func getBufFromBlob(remoteFileURL string) []byte {
 
 func main() {
 
+	// the file URL carries the SAS token, keep it out of the logs
+	log.SetOutput(&redact.Writer{W: os.Stderr})
+
 	type FileRequest struct {
 		SRNS           []string
 		TargetRegionID string
@@ -6,50 +6,153 @@ This is an example web application to demonstrate how to work with OSDU APIs:
 
 	* Find a well using Search API (/indexSearch)
 	- try me: http://localhost:8080/find?wellname=A05-01
-
-	* Fetch trajectory using Delivery API (/GetResources && azblob)
+	- export: http://localhost:8080/find?wellname=A05-01&format=csv (or ndjson, geojson)
+	- filter: http://localhost:8080/find?wellname=A05-01&resource_type=master-data/Well&facet=resource_type
+	- fuzzy:  http://localhost:8080/find?wellname=A5-1&fuzzy=true&min_score=0.6
+	- attributes: http://localhost:8080/find?field=Groningen&operator=NAM&spud_date_from=2005&spud_date_to=2010
+	- as JSON: curl -d '{"text":"*","attributes":[{"field":"status","op":"in","values":["Active","Drilling"]}]}' http://localhost:8080/find
+
+	* Save a search and run it again (local JSON file)
+	- save:   curl -X POST -d '{"name":"well-logs","text":"{well}","resource_types":["work-product-component/WellLog"]}' http://localhost:8080/searches
+	- list:   http://localhost:8080/searches
+	- run:    http://localhost:8080/find?saved=well-logs&well=A05-01
+	- delete: curl -X DELETE http://localhost:8080/searches/well-logs
+
+	* Watch searches for new, removed and new versions of records (OSDU_WATCH_SEARCHES)
+	- try me: http://localhost:8080/events?since=0
+
+	* Fetch trajectory using Delivery API (/GetResources && Azure Blob, S3, GCS or HTTPS storage)
 	- try me: http://localhost:8080/fetch?srn=srn:file/csv:6dd13750df8611e9b5df4fa704076d5c:1
+	- as ZIP: http://localhost:8080/fetch?srn=srn:file/csv:6dd13750df8611e9b5df4fa704076d5c:1&srn=srn:file/las2:83120238df6f11e9b5dfb1a6ac04af7f:1
+	- range:  curl -H "Range: bytes=0-1023" http://localhost:8080/fetch?srn=srn:file/csv:6dd13750df8611e9b5df4fa704076d5c:1
+	- region: http://localhost:8080/fetch?srn=srn:file/csv:6dd13750df8611e9b5df4fa704076d5c:1&region=westeurope (OSDU_TARGET_REGION)
+	- redirect: http://localhost:8080/fetch?srn=srn:file/csv:6dd13750df8611e9b5df4fa704076d5c:1&mode=redirect (OSDU_FETCH_MODES)
+	- progress: curl -N http://localhost:8080/fetch/progress/my-job while fetching with &job=my-job
+	- cache:  http://localhost:8080/fetch/cache (OSDU_FETCH_CACHE_DIR)
+	- bench:  go run ./cmd/srv bench -size 256 (block size and parallelism of downloads)
+
+	* Read a trajectory as survey stations (MD, inclination, azimuth, TVD, X/Y)
+	- try me: http://localhost:8080/trajectory?srn=srn:file/csv:6dd13750df8611e9b5df4fa704076d5c:1
+	- well path: http://localhost:8080/trajectory?srn=srn:file/csv:6dd13750df8611e9b5df4fa704076d5c:1&x=601234&y=5934567&vs_azimuth=45
+
+	* Read a LAS 2.0 or 3.0 well log as header, curves and tables (tops, core...)
+	- try me: http://localhost:8080/logs?srn=srn:file/las2:83120238df6f11e9b5dfb1a6ac04af7f:1
+	- curves: http://localhost:8080/logs?srn=srn:file/las2:83120238df6f11e9b5dfb1a6ac04af7f:1&curves=GR,RHOB
+	- window: http://localhost:8080/logs?srn=srn:file/las2:83120238df6f11e9b5dfb1a6ac04af7f:1&top=1500&base=2500&step=1&method=minmax&depth_unit=ft
+
+	* Upload a trajectory or well log using File service (/v2/files && Azure Blob or S3 storage)
+	- try me: curl -F file=@8438.csv http://localhost:8080/upload
+	- raw:    curl -T a05.las "http://localhost:8080/upload?filename=a05.las"
+	- client: go run ./cmd/srv upload 8438.csv a05.las
 
 */
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
-	"github.com/Azure/azure-storage-blob-go/azblob"
+	"fmt"
 	oidc "github.com/coreos/go-oidc"
+	"github.com/dmitry-epam/osdu-tutorials-go/quickstart/internal/redact"
 	"github.com/tidwall/gjson"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
 	"strconv"
 	"os"
+	"strings"
+	"time"
 )
 
 var (
 	// get OSDU API base URL from your Cloud Administrator
 	clientAPIBaseURL = os.Getenv("OSDU_API_BASE_URL")
 	
+	// region Delivery API should serve files from, a request can ask for another one
+	targetRegionID = os.Getenv("OSDU_TARGET_REGION")
+
 	// get Client ID and Client Secret from mgmt portal during app registration
 	clientAuthBaseURL = os.Getenv("OSDU_AUTH_BASE_URL")
 	clientID = os.Getenv("OSDU_CLIENT_ID")
 	clientSecret = os.Getenv("OSDU_CLIENT_SECRET")
+
+	// local file where saved searches are persisted
+	savedSearchesFile = getEnv("OSDU_SAVED_SEARCHES_FILE", "saved-searches.json")
+
+	// searches to re-run on a schedule as comma separated /find query strings,
+	// e.g. "saved=well-logs&well=A05-01", the watcher is off when empty
+	watchSearches   = os.Getenv("OSDU_WATCH_SEARCHES")
+	watchInterval   = getEnv("OSDU_WATCH_INTERVAL", "15m")
+	watchWebhookURL = os.Getenv("OSDU_WATCH_WEBHOOK_URL")
+
+	// directory for the cache of fetched files, the cache is off when empty
+	fetchCacheDir   = os.Getenv("OSDU_FETCH_CACHE_DIR")
+	fetchCacheMaxMB = getEnv("OSDU_FETCH_CACHE_MAX_MB", "1024")
+
+	// block size in bytes and number of parallel requests of file downloads,
+	// both are picked from the file size when not set
+	fetchBlockSize   = os.Getenv("OSDU_FETCH_BLOCK_SIZE")
+	fetchParallelism = os.Getenv("OSDU_FETCH_PARALLELISM")
+
+	// what happens to a file that does not match its checksum: "fail" does not
+	// deliver it, "flag" delivers and reports it, "off" skips the check
+	fetchVerify = getEnv("OSDU_FETCH_VERIFY", verifyFail)
+
+	// comma separated fetch modes clients may use: proxy, redirect, url;
+	// redirect and url hand out storage URLs carrying their SAS or signature
+	fetchModes = getEnv("OSDU_FETCH_MODES", modeProxy)
+
+	// File service new files are uploaded through and the partition, kind,
+	// ACL and legal tags their records get; lists are comma separated
+	fileServiceURL  = getEnv("OSDU_FILE_SERVICE_URL", clientAPIBaseURL)
+	dataPartitionID = os.Getenv("OSDU_DATA_PARTITION")
+	uploadKind      = getEnv("OSDU_UPLOAD_KIND", "osdu:wks:dataset--File.Generic:1.0.0")
+	uploadOwners    = os.Getenv("OSDU_UPLOAD_OWNERS")
+	uploadViewers   = os.Getenv("OSDU_UPLOAD_VIEWERS")
+	uploadLegalTags = os.Getenv("OSDU_UPLOAD_LEGAL_TAGS")
+	uploadCountries = getEnv("OSDU_UPLOAD_COUNTRIES", "US")
+	uploadMaxMB     = getEnv("OSDU_UPLOAD_MAX_MB", "1024")
 )
 
+// returns the value of the environment variable or the default when it is not set
+func getEnv(key, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// writes the value as indented JSON with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// file name and srn of a single file attached to a search result
+type FileStruct struct {
+	Filename string `json:"filename"`
+	Srn      string `json:"srn"`
+
+	// similarity to the well name, only set in fuzzy mode
+	Score *float64 `json:"score,omitempty"`
+}
+
 /*
   Function extracts file names and srns for each resource type from
   JSON response body and strips out everything else
 */
 func getFilesFromResults(responseBody []byte) map[string][]interface{} {
 
-	type FileStruct struct {
-		Filename string `json:"filename"`
-		Srn      string `json:"srn"`
-	}
-
 	// create a map to hold parsed files and srns
 	SRNs := map[string][]interface{}{}
 
@@ -70,7 +173,7 @@ func getFilesFromResults(responseBody []byte) map[string][]interface{} {
 
 			// add new file with its srn to resource type
 			SRNs[mapKey] = append(SRNs[mapKey], fileStruct)
-			log.Printf("Adding value: %s\n", fileStruct)
+			log.Printf("Adding value: %v\n", fileStruct)
 
 		}
 		return true // keep iterating
@@ -79,80 +182,434 @@ func getFilesFromResults(responseBody []byte) map[string][]interface{} {
 	return SRNs
 }
 
+// size of the buffer used to copy blob data to the client
+const streamBufferSize = 64 * 1024
+
 /*
-	This function constructs the pre-signed file URL based on
-	JSON response received from Delivery API
-	@ todo: Test with AWS
+	This function streams a file from the storage backend picked for its
+	location straight to the client, only one copy buffer is held in memory;
+	the download is cancelled when ctx is done, e.g. when the client disconnects.
+	A single byte range in rangeHeader is read from storage and sent as 206.
+	Whole files are checked against their checksum while they are streamed,
+	the last buffer is held back until the check passes (see fetchVerify),
+	and the status and computed digests follow the body as trailers when it is chunked.
+	Expired credentials are renewed and dropped connections resumed by src
 */
-func getFileURL(body []byte) string {
+func streamFile(ctx context.Context, w http.ResponseWriter, src *fileSource, rangeHeader string) error {
 
-	bodyResponse := string(body)
+	SRN := src.SRN
 
-	// getting parameters from JSON
-	endPoint := gjson.Get(bodyResponse, "Result.0.FileLocation.EndPoint").String()
-	bucket := gjson.Get(bodyResponse, "Result.0.FileLocation.Bucket").String()
-	key := gjson.Get(bodyResponse, "Result.0.FileLocation.Key").String()
-	SAS := gjson.Get(bodyResponse, "Result.0.FileLocation.TemporaryCredentials.SAS").String()
+	w.Header().Set("Accept-Ranges", "bytes")
+	rng, ranged := parseRangeHeader(rangeHeader)
 
-	log.Printf("Extracted file parameters: %s, %s, %s", endPoint, bucket, key)
+	// whole files are downloaded in parallel blocks, a range with a single request
+	var obj *storageObject
+	var backend storageBackend
+	var err error
+	if ranged {
+		obj, backend, err = src.Open(ctx, rng)
+	} else {
+		obj, backend, err = openChunked(ctx, src, fetchTuning)
+	}
+	if err == errRangeNotSatisfiable {
+		// the client is told the size of the file, which storage gives with the whole of it
+		if whole, _, err := src.Open(ctx, byteRange{}); err == nil {
+			whole.Body.Close()
+			if whole.TotalSize >= 0 {
+				w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(whole.TotalSize, 10))
+			}
+		}
+		writeFetchError(w, http.StatusRequestedRangeNotSatisfiable, "Requested range not satisfiable",
+			fetchError{SRN: SRN, Status: http.StatusRequestedRangeNotSatisfiable, Reason: rangeHeader})
+		return err
+	}
+	if err != nil {
+		status := storageErrorStatus(err)
+		writeFetchError(w, status, "Error downloading file",
+			fetchError{SRN: SRN, Status: status, Reason: storageErrorReason(err)})
+		return err
+	}
+	defer obj.Body.Close()
+
+	body := bufio.NewReaderSize(obj.Body, streamBufferSize)
+	setFileHeaders(w, SRN, src.Result().FileLocation, body)
+
+	size := obj.Size
+	log.Printf("Blob size is %s bytes (%s)", strconv.FormatInt(obj.TotalSize, 10), backend.Name())
+	if ranged && size >= 0 {
+		setContentRange(w, obj)
+		w.WriteHeader(http.StatusPartialContent)
+	} else if size >= 0 {
+		// the length is the progress total of the job too; over HTTP/1.1 the checksum
+		// trailers only follow bodies of unknown size, a cut off body tells of a mismatch
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+
+	if ranged || fetchVerify == verifyOff {
+		n, err := io.CopyBuffer(w, body, make([]byte, streamBufferSize))
+		if err != nil {
+			// headers are gone already, the short body tells the client it failed
+			return fmt.Errorf("streamed %d of %d bytes: %s", n, size, err)
+		}
+		log.Printf("Streamed %s bytes", strconv.FormatInt(n, 10))
+		return nil
+	}
 
-	// creating file download URL
-	fileURL := endPoint + bucket + "/" + key + "?" + SAS
-	//log.Printf("Created file URI: %s", fileURL)
+	expected := expectedChecksum(src.Result(), obj)
+	setExpectedChecksum(w, expected)
+	w.Header().Set("Trailer", "X-Checksum-Status, X-Checksum-Sha256, X-Checksum-Md5")
+	verify := newVerifier(expected)
+	held := &holdLastWriter{w: w}
 
-	return fileURL
+	n, err := io.CopyBuffer(io.MultiWriter(held, verify), body, make([]byte, streamBufferSize))
+	if err != nil {
+		return fmt.Errorf("streamed %d of %d bytes: %s", n, size, err)
+	}
+
+	status := verify.Status()
+	log.Printf("Streamed %s bytes, checksum %s, sha256 %s", strconv.FormatInt(n, 10), status, verify.SHA256())
+	if status == checksumMismatch {
+		log.Printf("Checksum mismatch for %s: expected %s %x from %s, got md5 %s sha256 %s",
+			SRN, expected.Algorithm, expected.Sum, expected.Source, verify.MD5(), verify.SHA256())
+		if fetchVerify == verifyFail {
+			// the held buffer is never sent, the caller cuts the connection off
+			return errChecksumMismatch
+		}
+	}
+	if err := held.Flush(); err != nil {
+		return err
+	}
+	w.Header().Set("X-Checksum-Status", status)
+	w.Header().Set("X-Checksum-Sha256", verify.SHA256())
+	w.Header().Set("X-Checksum-Md5", verify.MD5())
+	if status == checksumMismatch {
+		return errChecksumMismatch
+	}
+	return nil
+}
+
+// metadata struct
+type Metadata struct {
+	ResourceType []string `json:"resource_type"`
+
+	// additional metadata filters, field name to accepted values
+	Filters map[string][]string `json:"-"`
+}
+
+// metadata filters are sent next to resource_type in the same object
+func (m Metadata) MarshalJSON() ([]byte, error) {
+	fields := map[string][]string{}
+	for field, values := range m.Filters {
+		fields[field] = values
+	}
+	fields["resource_type"] = m.ResourceType
+	return json.Marshal(fields)
+}
+
+// search request struct
+type SearchRequest struct {
+	FullText string   `json:"fulltext"`
+	Metadata Metadata `json:"metadata"`
+	Facets   []string `json:"facets"`
 }
 
 /*
-	This function downloads data from Azure Blob storage
-	to an in-memory buffer, do not use in prod
-	since buffer can fail to grow
+	Function constructs an initial well search request,
+	the search term is assigned by the handler
 */
-func getBufFromBlob(ctx context.Context, remoteFileURL string) []byte {
+func newWellRequest() SearchRequest {
+	return SearchRequest{
+		FullText: "*",
+		Metadata: Metadata{ResourceType: []string{"master-data/Well", "work-product-component/WellLog", "work-product-component/WellborePath"}},
+		Facets:   []string{"resource_type"},
+	}
+}
 
-	var err error
+/*
+	Function calls Search API with the search request JSON,
+	the caller is responsible for closing the response body
+*/
+func postSearch(searchReq SearchRequest) (*http.Response, error) {
 
-	// When someone receives the URL, they access the SAS-protected resource with code like this:
-	u, _ := url.Parse(remoteFileURL)
+	// prepare request JSON from search request struct
+	buf, err := json.Marshal(searchReq)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Request JSON: %s", buf)
 
-	// Create an BlobURL object that wraps the blob URL (and its SAS) and a pipeline.
-	// When using a SAS URLs, anonymous credentials are required.
-	blobURL := azblob.NewBlobURL(*u, azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{}))
-	//log.Printf("Blob URL: %s", blobURL)
+	resp, err := http.Post(clientAPIBaseURL+"/indexSearch", "application/json", bytes.NewBuffer(buf))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("search API returned %s", resp.Status)
+	}
+
+	return resp, nil
+}
+
+/*
+	Find handler takes "wellname" (or a "saved" search name) and well attributes
+	as input parameters or a JSON body, makes Search API call to find the well
+	and writes results in the negotiated format
+*/
+func handleFind(w http.ResponseWriter, r *http.Request) {
 
-	// setting the properties for downloading a blob incl. the prgress function
-	options := azblob.DownloadFromBlobOptions{
-		BlockSize:   2048, // bytes, experiment depending on the file size
-		Parallelism: 1,    // start with 1 and increase if you have bigger files
-		Progress: func(bytesTransferred int64) {
-			log.Printf("Downloaded %s bytes", strconv.FormatInt(bytesTransferred, 10))
-		},
+	format, err := negotiateFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+
+	// assign the search term and filters passed to a handler,
+	// a posted JSON body replaces the query string parameters
+	var query SearchQuery
+	if r.Method == http.MethodPost {
+		query, err = searchQueryFromBody(r.Body)
+	} else {
+		query, err = findQuery(r.URL.Query())
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	wellReq := query.SearchRequest()
+
+	// fuzzy mode searches for every likely spelling of the well name
+	// and re-ranks the hits locally by their similarity
+	fuzzy, _ := strconv.ParseBool(r.URL.Query().Get("fuzzy"))
+	minScore := defaultMinScore
+	if fuzzy {
+		if len(wellNameTokens(query.Text)) == 0 {
+			http.Error(w, "fuzzy mode needs a well name", http.StatusBadRequest)
+			return
+		}
+		if s := r.URL.Query().Get("min_score"); s != "" {
+			if minScore, err = strconv.ParseFloat(s, 64); err != nil || !(minScore >= 0 && minScore <= 1) {
+				http.Error(w, "min_score must be a number between 0 and 1", http.StatusBadRequest)
+				return
+			}
+		}
+		wellReq.FullText = buildFullText(fuzzyFullText(query.Text), query.Attributes)
 	}
 
-	// first, we need to indentify the size of a blob to allocate buffer
-	props, err := blobURL.GetProperties(ctx, options.AccessConditions)
+	// call Search API with the well search request JSON
+	resp, err := postSearch(wellReq)
 	if err != nil {
-		log.Printf("Cannot read blob size: %s", err)
+		log.Printf("HTTP request failed with %s", err)
+		http.Error(w, "Search request failed: "+err.Error(), http.StatusBadGateway)
+		return
 	}
-	size := props.ContentLength()
-	log.Printf("Blob size is %s bytes", strconv.FormatInt(size, 10))
+	defer resp.Body.Close()
 
-	buf := make([]byte, size)
+	if fuzzy {
+		hits, err := rankFuzzyResults(query.Text, minScore, resp.Body)
+		if err != nil {
+			http.Error(w, "Reading search response failed: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		if format != formatJSON {
+			if err := exportHits(w, format, hits); err != nil {
+				log.Printf("Exporting results as %s failed with %s", format, err)
+			}
+			return
+		}
+		writeJSON(w, http.StatusOK, getFilesFromHits(hits))
+		return
+	}
 
-	// next, we're reading the blob into in-memory buffer
-	// @todo - there must be a better way to do this!
-	err = azblob.DownloadBlobToBuffer(ctx, blobURL, 0, 0, buf, options)
+	if format != formatJSON {
+		// export formats are streamed result by result
+		if err := exportResults(w, format, resp.Body); err != nil {
+			log.Printf("Exporting results as %s failed with %s", format, err)
+		}
+		return
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		http.Error(w, "Reading search response failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	// parse the results and extract files/srns for each resource type
+	SRNs := getFilesFromResults(body)
+	resJSON, err := json.Marshal(SRNs)
 	if err != nil {
-		log.Printf("Error downloading Blob: %s", err)
+		log.Printf("Marshalling result JSON failed with %s", err)
 	}
 
-	log.Printf("Buffer len: %v, cap: %v", len(buf), cap(buf))
+	// return response JSON back to browser
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resJSON)
+}
 
-	return buf
+// file request struct
+type FileRequest struct {
+	SRNS           []string
+	TargetRegionID string
+}
+
+/*
+	Function calls Delivery API with the file request JSON
+	and decodes the response
+*/
+func postGetResources(fileReq FileRequest) (*DeliveryResponse, error) {
+
+	// prepare request JSON from file request struct
+	searchRequest, err := json.Marshal(fileReq)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Request JSON: %s", searchRequest)
+
+	// call Delivery API with the file search request JSON
+	resp, err := http.Post(clientAPIBaseURL+"/GetResources", "application/json", bytes.NewBuffer(searchRequest))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("delivery API returned %s", resp.Status)
+	}
+
+	var delivery DeliveryResponse
+	if err := json.NewDecoder(resp.Body).Decode(&delivery); err != nil {
+		return nil, fmt.Errorf("decoding delivery response: %s", err)
+	}
+
+	return &delivery, nil
+}
+
+/*
+	Fetch handler takes one or more "srn" input parameters (or a posted file
+	request JSON), makes Delivery API call to get the pre-signed File URLs and
+	streams the file back to browser, several files are sent as a ZIP archive
+*/
+func handleFetch(w http.ResponseWriter, r *http.Request) {
+
+	// assign the search parameter to SRNs that are passed
+	var fileReq FileRequest
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&fileReq); err != nil {
+			writeFetchError(w, http.StatusBadRequest, "Invalid file request JSON: "+err.Error())
+			return
+		}
+	} else {
+		fileReq.SRNS = r.URL.Query()["srn"]
+	}
+	fileReq.SRNS = uniqueSRNs(fileReq.SRNS)
+	if len(fileReq.SRNS) == 0 {
+		writeFetchError(w, http.StatusBadRequest, "at least one srn is required")
+		return
+	}
+
+	if err := fileReq.setRegion(r); err != nil {
+		writeFetchError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if fileReq.TargetRegionID != "" {
+		w.Header().Set("X-Target-Region", fileReq.TargetRegionID)
+	}
+
+	// storage URLs are handed out only when the policy allows the mode
+	mode := strings.ToLower(r.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = modeProxy
+	}
+	if mode != modeProxy && mode != modeRedirect && mode != modeURL {
+		writeFetchError(w, http.StatusBadRequest, "mode must be proxy, redirect or url")
+		return
+	}
+	if !allowedFetchModes[mode] {
+		writeFetchError(w, http.StatusForbidden, "fetch mode "+strconv.Quote(mode)+" is not allowed on this server")
+		return
+	}
+	if mode != modeProxy {
+		writeStorageURLs(w, r, mode, fileReq)
+		return
+	}
+
+	// the job ID lets the client follow the download on /fetch/progress/{id}
+	job, err := fetchJobs.Start(r.URL.Query().Get("job"), fileReq.SRNS)
+	if err != nil {
+		writeFetchError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var failure string
+	defer func() { fetchJobs.Finish(job, failure) }()
+	w.Header().Set("X-Fetch-Job", job.ID)
+	w = &progressWriter{ResponseWriter: w, job: job}
+
+	// versioned files never change and are served from the local cache when it is on
+	if len(fileReq.SRNS) == 1 && fetchFiles != nil && fetchFiles.Cacheable(fileReq.SRNS[0]) {
+		serveCached(w, r, fileReq, job)
+		return
+	}
+
+	// resolve all SRNs with a single Delivery API call
+	delivery, err := postGetResources(fileReq)
+	if err != nil {
+		log.Printf("HTTP request failed with %s", err)
+		writeFetchError(w, http.StatusBadGateway, "Delivery request failed: "+err.Error())
+		return
+	}
+
+	if len(fileReq.SRNS) > 1 {
+		writeArchive(r.Context(), w, fileReq, delivery, job)
+		return
+	}
+
+	SRN := fileReq.SRNS[0]
+	if unresolved := delivery.Unresolved(fileReq.SRNS); len(unresolved) > 0 {
+		writeFetchError(w, http.StatusNotFound, "SRN was not resolved", unresolved...)
+		return
+	}
+
+	result := delivery.ResultsBySRN()[SRN]
+	log.Printf("Extracted file parameters: %s, %s, %s", result.FileLocation.EndPoint, result.FileLocation.Bucket, result.FileLocation.Key)
+	region, endPoint := result.FileLocation.servedFrom()
+	setServedFromHeaders(w, region, endPoint)
+
+	// stream the file back to browser, the request context
+	// cancels the download when the client goes away
+	if err := streamFile(r.Context(), w, newFileSource(result, fileReq.TargetRegionID), r.Header.Get("Range")); err != nil {
+		log.Printf("Error downloading blob: %s", redactSecrets(err.Error()))
+		failure = "download interrupted"
+		if err == errChecksumMismatch {
+			failure = err.Error()
+			if fetchVerify == verifyFail {
+				// the body has to be cut off or a chunked one would end as if it were complete
+				panic(http.ErrAbortHandler)
+			}
+		}
+	}
 }
 
 func main() {
 
+	// every log line goes through the scrubber, so no token or signature ends up in the logs
+	log.SetOutput(&redact.Writer{W: os.Stderr, Verbatim: []string{clientSecret}})
+
+	// "bench" measures chunked downloads against a local stand-in, no OSDU needed
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := runBenchmark(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// "upload" sends local files through File service
+	if len(os.Args) > 1 && os.Args[1] == "upload" {
+		if err := runUpload(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	ctx := context.Background()
 
 	// clientAuthBaseURL is used to discover /authorize, /token and
@@ -200,7 +657,7 @@ func main() {
 
 		oauth2Token, err := config.Exchange(ctx, r.URL.Query().Get("code"))
 		if err != nil {
-			http.Error(w, "Failed to exchange token: "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, "Failed to exchange token: "+redactSecrets(err.Error()), http.StatusInternalServerError)
 			return
 		}
 
@@ -212,7 +669,7 @@ func main() {
 
 		userInfo, err := provider.UserInfo(ctx, oauth2.StaticTokenSource(oauth2Token))
 		if err != nil {
-			http.Error(w, "Failed to get userinfo: "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, redactSecrets("Failed to get userinfo: "+err.Error()), http.StatusInternalServerError)
 			return
 		}
 
@@ -232,103 +689,77 @@ func main() {
 
 	///////////////////////////////////////////////////////////////////////////
 
-	//metadata struct
-	type Metadata struct {
-		ResourceType []string `json:"resource_type"`
-	}
-
-	// search request struct
-	type SearchRequest struct {
-		FullText string   `json:"fulltext"`
-		Metadata Metadata `json:"metadata"`
-		Facets   []string `json:"facets"`
-	}
-
-	// construct an initial well search request
-	wellReq := SearchRequest{
-		FullText: "*",
-		Metadata: Metadata{ResourceType: []string{"master-data/Well", "work-product-component/WellLog", "work-product-component/WellborePath"}},
-		Facets:   []string{"resource_type"},
-	}
-
-	log.Printf("Initialized well request: \n%s", wellReq)
+	log.Printf("Initialized well request: \n%s", newWellRequest())
 
 	// find handler takes "wellname" as input parameter and makes Search API call to find the well
-	http.HandleFunc("/find", func(w http.ResponseWriter, r *http.Request) {
-
-		wellName := r.URL.Query().Get("wellname")
+	http.HandleFunc("/find", handleFind)
 
-		// assign the search term to be a well passed to a handler
-		wellReq.FullText = wellName
+	// saved searches are kept in a local JSON file and can be run with /find?saved=<name>
+	savedSearches, err = openSavedSearchStore(savedSearchesFile)
+	if err != nil {
+		log.Printf("Failed to load saved searches, they are off until the file is fixed: %s", err)
+	}
+	http.HandleFunc("/searches", handleSavedSearches)
+	http.HandleFunc("/searches/", handleSavedSearch)
 
-		// prepare request JSON from well request struct
-		buf, err := json.Marshal(wellReq)
+	// the watcher re-runs searches in the background and reports
+	// added, removed and new versions of records to the log, a webhook and /events
+	if watchSearches != "" {
+		interval, err := time.ParseDuration(watchInterval)
 		if err != nil {
-			log.Printf("Error creating JSON: %s", err)
+			log.Fatalf("Invalid OSDU_WATCH_INTERVAL: %s", err)
 		}
-		log.Printf("Request JSON: %s", buf)
-
-		// call Search API with the well search request JSON
-		resp, err := http.Post(clientAPIBaseURL+"/indexSearch", "application/json", bytes.NewBuffer(buf))
-
-		if err != nil {
-			log.Printf("HTTP request failed with %s", err)
+		if interval <= 0 {
+			log.Fatalf("Invalid OSDU_WATCH_INTERVAL: %s is not above zero", watchInterval)
 		}
-		body, err := ioutil.ReadAll(resp.Body)
-
-		// parse the results and extract files/srns for each resource type
-		SRNs := getFilesFromResults(body)
-		resJSON, err := json.Marshal(SRNs)
+		sinks := []eventSink{logSink{}, recentEvents}
+		if watchWebhookURL != "" {
+			sinks = append(sinks, newWebhookSink(watchWebhookURL))
+		}
+		watcher, err := newSearchWatcher(watchSearches, interval, sinks...)
 		if err != nil {
-			log.Printf("Marshalling result JSON failed with %s", err)
+			log.Fatalf("Invalid OSDU_WATCH_SEARCHES: %s", err)
 		}
-
-		// return response JSON back to browser
-		w.Write(resJSON)
-	})
+		go watcher.Run(ctx)
+	}
+	http.HandleFunc("/events", handleEvents)
 
 	///////////////////////////////////////////////////////////////////////////
 
-	type FileRequest struct {
-		SRNS           []string
-		TargetRegionID string
-	}
-
-	// find handler takes "srn" as input parameter and makes Delivery API call
+	// fetch handler takes "srn" as input parameter and makes Delivery API call
 	// to get the pre-signed File URL to download
-	http.HandleFunc("/fetch", func(w http.ResponseWriter, r *http.Request) {
-
-		SRN := r.URL.Query().Get("srn")
-
-		// assign the search parameter to SRN that is passed;
-		// we can pass multiple SRNs if needed, just append them all
-		var fileReq FileRequest
-		fileReq.SRNS = append(fileReq.SRNS, SRN)
-
-		// prepare request JSON from file request struct
-		searchRequest, err := json.Marshal(fileReq)
-		if err != nil {
-			log.Printf("Error creating JSON: %s", err)
+	if fetchTuning, err = parseChunkTuning(fetchBlockSize, fetchParallelism); err != nil {
+		log.Fatalf("Invalid OSDU_FETCH_BLOCK_SIZE or OSDU_FETCH_PARALLELISM: %s", err)
+	}
+	if fetchVerify != verifyFail && fetchVerify != verifyFlag && fetchVerify != verifyOff {
+		log.Fatalf("Invalid OSDU_FETCH_VERIFY: %q, use fail, flag or off", fetchVerify)
+	}
+	if allowedFetchModes, err = parseFetchModes(fetchModes); err != nil {
+		log.Fatalf("Invalid OSDU_FETCH_MODES: %s", err)
+	}
+	if fetchCacheDir != "" {
+		maxMB, err := strconv.ParseInt(fetchCacheMaxMB, 10, 64)
+		if err != nil || maxMB <= 0 {
+			log.Fatalf("Invalid OSDU_FETCH_CACHE_MAX_MB: %q", fetchCacheMaxMB)
 		}
-		log.Printf("Request JSON: %s", searchRequest)
-
-		// call Delivery API with the file search request JSON
-		resp, err := http.Post(clientAPIBaseURL+"/GetResources", "application/json", bytes.NewBuffer(searchRequest))
-		if err != nil {
-			log.Printf("HTTP request failed with %s", err)
+		if fetchFiles, err = openFetchCache(fetchCacheDir, maxMB<<20); err != nil {
+			log.Fatalf("Failed to open fetch cache: %s", err)
 		}
+	}
+	http.HandleFunc("/fetch", handleFetch)
+	http.HandleFunc("/fetch/cache", handleFetchCache)
+	http.HandleFunc("/fetch/progress/", handleFetchProgress)
 
-		body, err := ioutil.ReadAll(resp.Body)
+	// trajectory handler parses the trajectory CSV of an SRN into survey stations
+	http.HandleFunc("/trajectory", handleTrajectory)
 
-		// construct pre-signed blob URL from response JSON
-		remoteURL := getFileURL(body)
+	// logs handler parses the LAS file of an SRN into its header and curves
+	http.HandleFunc("/logs", handleLogs)
 
-		// download Blob to a buffer using pre-signed URL
-		buf := getBufFromBlob(ctx, remoteURL)
+	///////////////////////////////////////////////////////////////////////////
 
-		// return response CSV back to browser
-		w.Write(buf)
-	})
+	// upload handler stores a file through File service and registers its record
+	http.HandleFunc("/upload", handleUpload)
 
 	log.Printf("listening on http://%s/", "0.0.0.0:8080")
 	log.Fatal(http.ListenAndServe("0.0.0.0:8080", nil))
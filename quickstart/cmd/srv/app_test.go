@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/*
+	newDeliveryStandIn answers /GetResources with the results of the
+	requested SRNs and lists the others as unprocessed; point
+	clientAPIBaseURL at it for the test
+*/
+func newDeliveryStandIn(results ...DeliveryResult) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var fileReq FileRequest
+		if r.URL.Path != "/GetResources" || json.NewDecoder(r.Body).Decode(&fileReq) != nil {
+			http.NotFound(w, r)
+			return
+		}
+		var delivery DeliveryResponse
+		for _, SRN := range fileReq.SRNS {
+			resolved := false
+			for _, result := range results {
+				if result.SRN == SRN {
+					delivery.Result = append(delivery.Result, result)
+					resolved = true
+				}
+			}
+			if !resolved {
+				delivery.UnprocessedSRNs = append(delivery.UnprocessedSRNs, UnprocessedSRN{SRN: SRN})
+			}
+		}
+		json.NewEncoder(w).Encode(delivery)
+	}))
+}
+
+func md5Hex(data string) string {
+	sum := md5.Sum([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// a whole file is sent with its length, which is also the progress total, whether it is verified or not
+func TestFetchContentLength(t *testing.T) {
+
+	const SRN = "srn:file/csv:6dd13750df8611e9b5df4fa704076d5c:1"
+	// more than a copy buffer, so a file failing its check has sent its headers
+	data := "MD,INC,AZI\n" + strings.Repeat("100,1.5,45\n", 2*streamBufferSize/11)
+	blobs := newStandIn(map[string][]byte{"/tno/data/trajectories/8438.csv": []byte(data)})
+	defer blobs.Close()
+
+	defer func(base, verify string, cache *fetchCache) {
+		clientAPIBaseURL, fetchVerify, fetchFiles = base, verify, cache
+	}(clientAPIBaseURL, fetchVerify, fetchFiles)
+	fetchFiles = nil
+
+	for _, c := range []struct {
+		verify, checksum string
+		complete         bool
+	}{
+		{verify: verifyFail, checksum: md5Hex(data), complete: true},
+		{verify: verifyFlag, checksum: md5Hex(data), complete: true},
+		{verify: verifyOff, checksum: md5Hex(data), complete: true},
+		{verify: verifyOff, checksum: md5Hex("changed"), complete: true},
+		// the held back end of a file that fails its check is never sent
+		{verify: verifyFail, checksum: md5Hex("changed"), complete: false},
+	} {
+		t.Run(c.verify+" "+c.checksum, func(t *testing.T) {
+			delivery := newDeliveryStandIn(DeliveryResult{SRN: SRN, Checksum: c.checksum,
+				FileLocation: FileLocation{EndPoint: blobs.URL, Key: "/tno/data/trajectories/8438.csv"}})
+			defer delivery.Close()
+			clientAPIBaseURL, fetchVerify = delivery.URL, c.verify
+
+			srv := httptest.NewServer(http.HandlerFunc(handleFetch))
+			defer srv.Close()
+			job := "length-" + c.verify + "-" + c.checksum[:8]
+			resp, err := http.Get(srv.URL + "/fetch?" + url.Values{"srn": {SRN}, "job": {job}}.Encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if got := resp.Header.Get("Content-Length"); got != strconv.Itoa(len(data)) {
+				t.Errorf("Content-Length %q, want %d", got, len(data))
+			}
+			if c.complete && (err != nil || string(body) != data) {
+				t.Errorf("body %q, %v", body, err)
+			}
+			if !c.complete && err == nil {
+				t.Errorf("a file failing its check was sent whole: %q", body)
+			}
+
+			// the last event on /fetch/progress carries the total of the job
+			rec := httptest.NewRecorder()
+			handleFetchProgress(rec, httptest.NewRequest(http.MethodGet, "/fetch/progress/"+job, nil))
+			events := strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n")
+			var event progressEvent
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(strings.Split(events[len(events)-1], "\n")[1], "data: ")), &event); err != nil {
+				t.Fatalf("%s: %s", rec.Body, err)
+			}
+			if event.Total != int64(len(data)) {
+				t.Errorf("progress total %d, want %d", event.Total, len(data))
+			}
+		})
+	}
+}
+
+// a range past the end of the file is answered with the size of the file
+func TestFetchRangeNotSatisfiable(t *testing.T) {
+
+	const SRN = "srn:file/csv:6dd13750df8611e9b5df4fa704076d5c:1"
+	blobs := newStandIn(map[string][]byte{"/tno/8438.csv": []byte("MD,INC,AZI\n0,0,0\n")})
+	defer blobs.Close()
+	delivery := newDeliveryStandIn(DeliveryResult{SRN: SRN, FileLocation: FileLocation{EndPoint: blobs.URL, Key: "/tno/8438.csv"}})
+	defer delivery.Close()
+
+	defer func(base string, cache *fetchCache) { clientAPIBaseURL, fetchFiles = base, cache }(clientAPIBaseURL, fetchFiles)
+	clientAPIBaseURL, fetchFiles = delivery.URL, nil
+
+	req := httptest.NewRequest(http.MethodGet, "/fetch?srn="+url.QueryEscape(SRN), nil)
+	req.Header.Set("Range", "bytes=100-")
+	rec := httptest.NewRecorder()
+	handleFetch(rec, req)
+
+	if rec.Code != http.StatusRequestedRangeNotSatisfiable || rec.Header().Get("Content-Range") != "bytes */17" {
+		t.Errorf("%d with Content-Range %q: %s", rec.Code, rec.Header().Get("Content-Range"), rec.Body)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// DeliveryResponse is the body returned by Delivery API /GetResources
+type DeliveryResponse struct {
+	UnprocessedSRNs []UnprocessedSRN `json:"UnprocessedSRNs"`
+	Result          []DeliveryResult `json:"Result"`
+}
+
+// DeliveryResult is the location of a single resolved SRN, with the
+// checksum from the file metadata when the platform returns it
+type DeliveryResult struct {
+	FileLocation      FileLocation `json:"FileLocation"`
+	SRN               string       `json:"SRN"`
+	Checksum          string       `json:"Checksum,omitempty"`
+	ChecksumAlgorithm string       `json:"ChecksumAlgorithm,omitempty"`
+}
+
+// FileLocation tells where the file is stored and how to access it,
+// which fields are set depends on the cloud the platform runs in
+type FileLocation struct {
+	Bucket               string               `json:"Bucket"`
+	EndPoint             string               `json:"EndPoint"`
+	Key                  string               `json:"Key"`
+	Region               string               `json:"Region,omitempty"`
+	Provider             string               `json:"Provider,omitempty"`
+	SignedURL            string               `json:"SignedUrl,omitempty"`
+	TemporaryCredentials TemporaryCredentials `json:"TemporaryCredentials"`
+}
+
+// TemporaryCredentials grant short lived access to the stored file:
+// a SAS token on Azure, session credentials on AWS, a signed URL or token on GCP
+type TemporaryCredentials struct {
+	SAS             string `json:"SAS,omitempty"`
+	AccessKeyID     string `json:"AccessKeyId,omitempty"`
+	SecretAccessKey string `json:"SecretAccessKey,omitempty"`
+	SessionToken    string `json:"SessionToken,omitempty"`
+	AccessToken     string `json:"AccessToken,omitempty"`
+	SignedURL       string `json:"SignedUrl,omitempty"`
+	Expiration      string `json:"Expiration,omitempty"`
+}
+
+// UnprocessedSRN is an SRN Delivery API could not resolve,
+// sent either as a plain string or as an object with a reason
+type UnprocessedSRN struct {
+	SRN    string `json:"srn"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func (u *UnprocessedSRN) UnmarshalJSON(data []byte) error {
+
+	if err := json.Unmarshal(data, &u.SRN); err == nil {
+		return nil
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return fmt.Errorf("unprocessed SRN must be a string or an object: %s", err)
+	}
+	for key, value := range obj {
+		s, _ := value.(string)
+		switch strings.ToLower(key) {
+		case "srn":
+			u.SRN = s
+		case "reason", "message", "error":
+			u.Reason = s
+		}
+	}
+	return nil
+}
+
+// region IDs accepted in TargetRegionID, they end up in response headers
+var regionPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// sets the region asked for in ?region=, which wins over the one of the deployment
+func (f *FileRequest) setRegion(r *http.Request) error {
+	if region := r.URL.Query().Get("region"); region != "" {
+		f.TargetRegionID = region
+	}
+	if f.TargetRegionID == "" {
+		f.TargetRegionID = targetRegionID
+	}
+	if f.TargetRegionID != "" && !regionPattern.MatchString(f.TargetRegionID) {
+		return fmt.Errorf("invalid region %q", f.TargetRegionID)
+	}
+	return nil
+}
+
+/*
+	Function tells where a location serves the file from: the region storage
+	reported, empty when it did not (the region asked for is no proof of where
+	the copy is), and the host of the storage end point or signed URL (no path
+	or query, so no credentials)
+*/
+func (l FileLocation) servedFrom() (region, endPoint string) {
+
+	region = l.Region
+	for _, raw := range []string{l.EndPoint, l.signedURL()} {
+		if u, err := url.Parse(raw); err == nil && u.Host != "" {
+			return region, u.Host
+		}
+	}
+	if l.Bucket != "" && l.Region != "" && l.TemporaryCredentials.AccessKeyID != "" {
+		return region, l.Bucket + ".s3." + l.Region + ".amazonaws.com"
+	}
+	return region, ""
+}
+
+// reports the region and end point a file is served from in response headers
+func setServedFromHeaders(w http.ResponseWriter, region, endPoint string) {
+	if region != "" {
+		w.Header().Set("X-Storage-Region", region)
+	}
+	if endPoint != "" {
+		w.Header().Set("X-Storage-Endpoint", endPoint)
+	}
+}
+
+// returns the result for every resolved SRN keyed by the SRN
+func (d *DeliveryResponse) ResultsBySRN() map[string]DeliveryResult {
+	results := map[string]DeliveryResult{}
+	for _, result := range d.Result {
+		results[result.SRN] = result
+	}
+	return results
+}
+
+/*
+	Function lists the requested SRNs Delivery API did not resolve, with the
+	reason it gave in UnprocessedSRNs or a generic one for SRNs missing silently
+*/
+func (d *DeliveryResponse) Unresolved(SRNs []string) []fetchError {
+
+	reasons := map[string]string{}
+	for _, u := range d.UnprocessedSRNs {
+		reasons[u.SRN] = u.Reason
+	}
+	resolved := d.ResultsBySRN()
+
+	var unresolved []fetchError
+	for _, srn := range SRNs {
+		if _, ok := resolved[srn]; ok {
+			continue
+		}
+		reason, ok := reasons[srn]
+		if !ok || reason == "" {
+			reason = "not found by Delivery API"
+			if ok {
+				reason = "listed as unprocessed by Delivery API"
+			}
+		}
+		unresolved = append(unresolved, fetchError{SRN: srn, Status: http.StatusNotFound, Reason: reason})
+	}
+	return unresolved
+}
+
+// fetchError says which SRN failed and why
+type fetchError struct {
+	SRN    string `json:"srn"`
+	Status int    `json:"status"`
+	Reason string `json:"reason"`
+}
+
+func (e fetchError) Error() string {
+	return e.SRN + ": " + e.Reason
+}
+
+// errorResponse is the JSON body of every failed /fetch request
+type errorResponse struct {
+	Error  string       `json:"error"`
+	Errors []fetchError `json:"errors,omitempty"`
+}
+
+// writes a JSON error body, errs lists the SRNs that failed
+func writeFetchError(w http.ResponseWriter, status int, message string, errs ...fetchError) {
+	for i := range errs {
+		errs[i].Reason = redactSecrets(errs[i].Reason)
+	}
+	writeJSON(w, status, errorResponse{Error: redactSecrets(message), Errors: errs})
+}
+
+/*
+	Function maps a storage download error to the HTTP status reported to
+	the client: a missing file is a 404, anything else a bad gateway
+*/
+func storageErrorStatus(err error) int {
+	if storageErrorCode(err) == http.StatusNotFound {
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
+
+// returns the HTTP status storage answered with, 0 when it did not answer
+func storageErrorCode(err error) int {
+	var storageErr azblob.StorageError
+	if errors.As(err, &storageErr) && storageErr.Response() != nil {
+		return storageErr.Response().StatusCode
+	}
+	var statusErr *storageStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode
+	}
+	return 0
+}
+
+/*
+	Function describes a storage download error in one line for the client,
+	without the request dump azblob adds, which carries the SAS token
+*/
+func storageErrorReason(err error) string {
+	var storageErr azblob.StorageError
+	if errors.As(err, &storageErr) && storageErr.Response() != nil {
+		reason := "storage returned " + storageErr.Response().Status
+		if code := storageErr.ServiceCode(); code != "" {
+			reason += " (" + string(code) + ")"
+		}
+		return reason
+	}
+	var statusErr *storageStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Error()
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return "storage request failed: " + redactSecrets(urlErr.Err.Error())
+	}
+	return "storage: " + redactSecrets(err.Error())
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// number of files downloaded at the same time for an archive
+const archiveParallelism = 4
+
+// name of the manifest entry added to every archive
+const manifestName = "manifest.json"
+
+// manifestEntry describes one requested SRN in the archive manifest
+type manifestEntry struct {
+	SRN      string `json:"srn"`
+	Filename string `json:"filename,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+	MD5      string `json:"md5,omitempty"`
+	Checksum string `json:"checksum,omitempty"` // verification status
+	Region   string `json:"region,omitempty"`
+	EndPoint string `json:"endpoint,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// archiveFile is a file downloaded to a temporary location
+type archiveFile struct {
+	manifestEntry
+	status  int
+	tmpPath string
+}
+
+// removes empty and repeated SRNs keeping the order they were given in
+func uniqueSRNs(SRNs []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, srn := range SRNs {
+		srn = strings.TrimSpace(srn)
+		if srn != "" && !seen[srn] {
+			seen[srn] = true
+			unique = append(unique, srn)
+		}
+	}
+	return unique
+}
+
+/*
+	Function downloads all resolved files concurrently to temporary files
+	and streams them back as a single ZIP archive with a manifest listing
+	each SRN with its checksum or the reason it is missing; the archive is
+	sent with 207 Multi-Status when some of the SRNs failed. The downloads
+	are reported as the downloading phase of the job, the total growing as
+	each file is opened
+*/
+func writeArchive(ctx context.Context, w http.ResponseWriter, fileReq FileRequest, delivery *DeliveryResponse, job *fetchJob) {
+
+	SRNs := fileReq.SRNS
+
+	bySRN := delivery.ResultsBySRN()
+	unresolved := map[string]fetchError{}
+	for _, e := range delivery.Unresolved(SRNs) {
+		unresolved[e.SRN] = e
+	}
+
+	files := make([]archiveFile, len(SRNs))
+	names := archiveFilenames(SRNs, bySRN)
+
+	job.SetPhase(phaseDownloading)
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, archiveParallelism)
+	for i, srn := range SRNs {
+		files[i].SRN = srn
+		if e, ok := unresolved[srn]; ok {
+			files[i].Error, files[i].status = e.Reason, e.Status
+			continue
+		}
+		files[i].Filename = names[i]
+		files[i].Region, files[i].EndPoint = bySRN[srn].FileLocation.servedFrom()
+
+		wg.Add(1)
+		go func(f *archiveFile, result DeliveryResult) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			if err := downloadToTempFile(ctx, f, newFileSource(result, fileReq.TargetRegionID), job); err != nil {
+				log.Printf("Error downloading %s: %s", f.SRN, redactSecrets(err.Error()))
+				f.Error, f.status = storageErrorReason(err), storageErrorStatus(err)
+			}
+		}(&files[i], bySRN[srn])
+	}
+	wg.Wait()
+
+	defer func() {
+		for _, f := range files {
+			if f.tmpPath != "" {
+				os.Remove(f.tmpPath)
+			}
+		}
+	}()
+
+	var failed []fetchError
+	for _, f := range files {
+		if f.Error != "" {
+			failed = append(failed, fetchError{SRN: f.SRN, Status: f.status, Reason: f.Error})
+		}
+	}
+
+	if len(failed) == len(files) {
+		// nothing to archive, report 404 when no SRN was found at all
+		status := http.StatusNotFound
+		for _, e := range failed {
+			if e.Status != http.StatusNotFound {
+				status = http.StatusBadGateway
+			}
+		}
+		writeFetchError(w, status, "None of the SRNs could be fetched", failed...)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="osdu-files.zip"`)
+	if len(failed) > 0 {
+		w.WriteHeader(http.StatusMultiStatus)
+	}
+
+	if err := writeZip(w, files); err != nil {
+		log.Printf("Error writing archive: %s", err)
+	}
+}
+
+/*
+	Function picks a file name inside the archive for every SRN from the blob
+	key (e.g. 8438.csv), repeated names get a numeric suffix
+*/
+func archiveFilenames(SRNs []string, bySRN map[string]DeliveryResult) []string {
+
+	names := make([]string, len(SRNs))
+	used := map[string]bool{manifestName: true}
+
+	for i, srn := range SRNs {
+		name := fileNameFor(srn, bySRN[srn].FileLocation)
+
+		ext := path.Ext(name)
+		candidate := name
+		for n := 2; used[candidate]; n++ {
+			candidate = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), n, ext)
+		}
+		used[candidate] = true
+		names[i] = candidate
+	}
+
+	return names
+}
+
+/*
+	Function downloads the blob to a temporary file, computing its size and
+	checksums and verifying it; a file not matching its checksum is left out
+	of the archive unless fetchVerify only flags it in the manifest
+*/
+func downloadToTempFile(ctx context.Context, f *archiveFile, src *fileSource, job *fetchJob) error {
+
+	obj, _, err := openChunked(ctx, src, fetchTuning)
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+	if obj.Size > 0 {
+		job.AddTotal(obj.Size)
+	}
+
+	tmp, err := ioutil.TempFile("", "osdu-fetch-")
+	if err != nil {
+		return err
+	}
+	f.tmpPath = tmp.Name()
+
+	verify := newVerifier(expectedChecksum(src.Result(), obj))
+	n, err := io.CopyBuffer(io.MultiWriter(job.Writer(tmp), verify), obj.Body, make([]byte, streamBufferSize))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	f.Size, f.SHA256, f.MD5 = n, verify.SHA256(), verify.MD5()
+	if fetchVerify != verifyOff {
+		f.Checksum = verify.Status()
+	}
+	if f.Checksum == checksumMismatch && fetchVerify == verifyFail {
+		return errChecksumMismatch
+	}
+	return nil
+}
+
+// writes downloaded files in request order followed by the manifest
+func writeZip(w io.Writer, files []archiveFile) error {
+
+	zw := zip.NewWriter(w)
+	manifest := make([]manifestEntry, 0, len(files))
+	now := time.Now()
+
+	for _, f := range files {
+		manifest = append(manifest, f.manifestEntry)
+		if f.Error != "" {
+			continue
+		}
+
+		header := &zip.FileHeader{Name: f.Filename, Method: zip.Deflate, Modified: now}
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		tmp, err := os.Open(f.tmpPath)
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyBuffer(entry, tmp, make([]byte, streamBufferSize))
+		tmp.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	entry, err := zw.CreateHeader(&zip.FileHeader{Name: manifestName, Method: zip.Deflate, Modified: now})
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return err
+	}
+	if _, err := entry.Write(data); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// the files are downloaded as the downloading phase of the job and sent in request order with the manifest
+func TestWriteArchive(t *testing.T) {
+
+	blobs := newStandIn(map[string][]byte{
+		"/tno/8438.csv": []byte("MD,INC,AZI\n0,0,0\n"),
+		"/tno/a05.las":  []byte("~V\nVERS. 2.0\n"),
+	})
+	defer blobs.Close()
+
+	SRNs := []string{"srn:file/csv:6dd:1", "srn:file/las2:831:1", "srn:file/csv:unknown:1"}
+	delivery := &DeliveryResponse{
+		Result: []DeliveryResult{
+			{SRN: SRNs[0], FileLocation: FileLocation{EndPoint: blobs.URL, Key: "/tno/8438.csv"}},
+			{SRN: SRNs[1], FileLocation: FileLocation{EndPoint: blobs.URL, Key: "/tno/a05.las"}},
+		},
+		UnprocessedSRNs: []UnprocessedSRN{{SRN: SRNs[2]}},
+	}
+	job := &fetchJob{}
+	rec := httptest.NewRecorder()
+	writeArchive(context.Background(), rec, FileRequest{SRNS: SRNs}, delivery, job)
+
+	if rec.Code != http.StatusMultiStatus {
+		t.Errorf("status %d, want 207 for the unresolved SRN", rec.Code)
+	}
+	if p := job.Progress(); p.Phase != phaseDownloading || p.Total != 30 || p.Transferred != 30 {
+		t.Errorf("progress %+v, want 30 of 30 bytes downloading", p)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, " "); got != "8438.csv a05.las manifest.json" {
+		t.Fatalf("archive has %s", got)
+	}
+	f, err := archive.File[2].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, _ := ioutil.ReadAll(f)
+	if !strings.Contains(string(manifest), `"srn": "srn:file/csv:unknown:1"`) || !strings.Contains(string(manifest), `"size": 17`) {
+		t.Errorf("manifest %s", manifest)
+	}
+}
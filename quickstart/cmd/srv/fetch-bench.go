@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+/*
+	Benchmark command downloads a generated file from a local blob stand-in
+	with several block sizes and parallelism levels and prints the throughput,
+	the stand-in adds latency to every request and limits the bandwidth of
+	each connection the way a remote storage account would:
+
+	go run ./cmd/srv bench -size 256 -latency 40ms -rate 20
+*/
+func runBenchmark(args []string) error {
+
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	sizeMB := flags.Int("size", 128, "size of the generated file in MB")
+	latency := flags.Duration("latency", 30*time.Millisecond, "latency added to every request")
+	rateMB := flags.Float64("rate", 25, "bandwidth of a single connection in MB/s, 0 for unlimited")
+	blocks := flags.String("block", "256K,1M,4M,auto", "comma separated block sizes to try")
+	parallelism := flags.String("parallelism", "1,4,8,auto", "comma separated parallelism levels to try")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	blockSizes, err := parseSizes(*blocks)
+	if err != nil {
+		return err
+	}
+	levels, err := parseSizes(*parallelism)
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, *sizeMB<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	want := sha256.Sum256(data)
+
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		time.Sleep(*latency)
+		if *rateMB > 0 {
+			w = &throttledWriter{ResponseWriter: w, bytesPerSecond: *rateMB * (1 << 20), start: time.Now()}
+		}
+		http.ServeContent(w, r, "blob.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+	// the stand-in never expires, there is nothing to resolve again
+	src := &fileSource{SRN: "bench", result: DeliveryResult{
+		FileLocation: FileLocation{Provider: backendHTTPS, SignedURL: server.URL + "/blob.bin"},
+	}}
+
+	fmt.Printf("%d MB file, %s latency, %.0f MB/s per connection\n\n", *sizeMB, *latency, *rateMB)
+	table := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "block\tparallelism\trequests\tseconds\tMB/s\t")
+
+	for _, blockSize := range blockSizes {
+		for _, level := range levels {
+			tuning := chunkTuning{BlockSize: blockSize, Parallelism: int(level)}
+			atomic.StoreInt64(&requests, 0)
+
+			start := time.Now()
+			obj, _, err := openChunked(context.Background(), src, tuning)
+			if err != nil {
+				return err
+			}
+			hash := sha256.New()
+			_, err = io.Copy(hash, obj.Body)
+			obj.Body.Close()
+			if err != nil {
+				return err
+			}
+			elapsed := time.Since(start)
+			if !bytes.Equal(hash.Sum(nil), want[:]) {
+				return fmt.Errorf("block %d, parallelism %d: downloaded file does not match", blockSize, level)
+			}
+
+			planBlock, planLevel := tuning.Plan(int64(len(data)))
+			fmt.Fprintf(table, "%s\t%d\t%d\t%.2f\t%.1f\t\n", formatSize(planBlock, blockSize == 0),
+				planLevel, atomic.LoadInt64(&requests), elapsed.Seconds(), float64(*sizeMB)/elapsed.Seconds())
+		}
+	}
+
+	return table.Flush()
+}
+
+// parses comma separated sizes with an optional K or M suffix, "auto" is 0
+func parseSizes(list string) ([]int64, error) {
+	var sizes []int64
+	for _, s := range strings.Split(list, ",") {
+		s = strings.ToUpper(strings.TrimSpace(s))
+		if s == "AUTO" {
+			sizes = append(sizes, 0)
+			continue
+		}
+		unit := int64(1)
+		switch {
+		case strings.HasSuffix(s, "K"):
+			unit, s = 1<<10, strings.TrimSuffix(s, "K")
+		case strings.HasSuffix(s, "M"):
+			unit, s = 1<<20, strings.TrimSuffix(s, "M")
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid size %q", s)
+		}
+		sizes = append(sizes, n*unit)
+	}
+	return sizes, nil
+}
+
+// prints a block size in K or M, marking sizes picked automatically
+func formatSize(size int64, auto bool) string {
+	s := strconv.FormatInt(size>>10, 10) + "K"
+	if size%(1<<20) == 0 {
+		s = strconv.FormatInt(size>>20, 10) + "M"
+	}
+	if auto {
+		s += " (auto)"
+	}
+	return s
+}
+
+// throttledWriter limits how fast a response is written
+type throttledWriter struct {
+	http.ResponseWriter
+	bytesPerSecond float64
+	start          time.Time
+	written        int64
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	n, err := t.ResponseWriter.Write(p)
+	t.written += int64(n)
+	due := time.Duration(float64(t.written) / t.bytesPerSecond * float64(time.Second))
+	if wait := due - time.Since(t.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// name of the file listing the cached SRNs inside the cache directory
+const cacheIndexName = "index.json"
+
+// cache of fetched files, nil when OSDU_FETCH_CACHE_DIR is not set
+var fetchFiles *fetchCache
+
+// cacheEntry is a cached SRN pointing to the file content by its checksum
+type cacheEntry struct {
+	SRN      string    `json:"srn"`
+	Filename string    `json:"filename"`
+	SHA256   string    `json:"sha256"`
+	Size     int64     `json:"size"`
+	MD5      string    `json:"md5,omitempty"`
+	Checksum string    `json:"checksum,omitempty"` // verification status when it was downloaded
+	Region   string    `json:"region,omitempty"`
+	EndPoint string    `json:"endpoint,omitempty"`
+	LastUsed time.Time `json:"last_used"`
+
+	// of the file on disk when its checksum was last verified
+	ModTime time.Time `json:"mod_time"`
+}
+
+// cacheStats are the counters reported on /fetch/cache
+type cacheStats struct {
+	Entries   int   `json:"entries"`
+	Size      int64 `json:"size"`
+	MaxSize   int64 `json:"max_size"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Shared    int64 `json:"shared"`
+	Evictions int64 `json:"evictions"`
+	Corrupted int64 `json:"corrupted"`
+}
+
+// cacheFill is a download in progress, concurrent requests for the same SRN wait on it
+type cacheFill struct {
+	done  chan struct{}
+	entry cacheEntry
+	err   error
+}
+
+/*
+	fetchCache keeps fetched files on disk. Versioned SRNs never change, so
+	a file is downloaded once and served from disk until it is evicted; the
+	content is stored under its SHA-256 (SRNs with the same content share one
+	file), which is computed as it is downloaded; a file is served as long as
+	its size and modification time are the ones it was verified with, and the
+	least recently used entries are evicted when the cache grows over its
+	size limit
+*/
+type fetchCache struct {
+	dir     string
+	maxSize int64
+
+	mu       sync.Mutex
+	entries  map[string]*cacheEntry // by SRN
+	blobs    map[string]int         // number of entries using every checksum
+	size     int64
+	inflight map[string]*cacheFill
+	stats    cacheStats
+}
+
+// opens the cache in dir, creating the directory and reading the index left by a previous run
+func openFetchCache(dir string, maxSize int64) (*fetchCache, error) {
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &fetchCache{
+		dir:      dir,
+		maxSize:  maxSize,
+		entries:  map[string]*cacheEntry{},
+		blobs:    map[string]int{},
+		inflight: map[string]*cacheFill{},
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, cacheIndexName))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*cacheEntry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("reading %s: %s", cacheIndexName, err)
+	}
+	for _, e := range list {
+		// files removed by hand are dropped from the index, changed ones verified again
+		info, err := os.Stat(c.blobPath(e.SHA256))
+		if err != nil {
+			continue
+		}
+		if info.Size() != e.Size || !info.ModTime().Equal(e.ModTime) {
+			if sum, err := hashFile(c.blobPath(e.SHA256)); err != nil || sum != e.SHA256 || info.Size() != e.Size {
+				log.Printf("Dropping cached %s: the file changed on disk", e.SRN)
+				continue
+			}
+			e.ModTime = info.ModTime()
+		}
+		c.add(e)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict("")
+	return c, c.write()
+}
+
+// reports whether the SRN carries a version, only those are immutable
+func (c *fetchCache) Cacheable(srn string) bool {
+	_, version := splitSRNVersion(srn)
+	return version != ""
+}
+
+/*
+	Function returns the cached file for the SRN opened for reading, calling
+	fill to download it on a miss; fill writes the content and returns the
+	file name and checksum details, and runs only once for concurrent requests of the same SRN.
+	A file larger than the whole cache is returned without being kept
+*/
+func (c *fetchCache) Get(srn string, fill func(w io.Writer) (cacheEntry, error)) (cacheEntry, *os.File, error) {
+
+	if entry, f, ok := c.lookup(srn); ok {
+		return entry, f, nil
+	}
+
+	c.mu.Lock()
+	if running, ok := c.inflight[srn]; ok {
+		c.stats.Shared++
+		c.mu.Unlock()
+		<-running.done
+		if running.err != nil {
+			return cacheEntry{}, nil, running.err
+		}
+		f, err := os.Open(c.blobPath(running.entry.SHA256))
+		if os.IsNotExist(err) {
+			// the file was too big to keep or is evicted already
+			return c.Get(srn, fill)
+		}
+		return running.entry, f, err
+	}
+	c.stats.Misses++
+	running := &cacheFill{done: make(chan struct{})}
+	c.inflight[srn] = running
+	c.mu.Unlock()
+
+	entry, f, err := c.fill(srn, fill)
+
+	c.mu.Lock()
+	delete(c.inflight, srn)
+	c.mu.Unlock()
+	running.entry, running.err = entry, err
+	close(running.done)
+
+	return entry, f, err
+}
+
+/*
+	Function opens a cached file after checking its size and modification
+	time are the ones its checksum was verified with, so a hit does not read
+	the file twice; files that changed on disk are dropped
+*/
+func (c *fetchCache) lookup(srn string) (cacheEntry, *os.File, bool) {
+
+	c.mu.Lock()
+	e, ok := c.entries[srn]
+	if !ok {
+		c.mu.Unlock()
+		return cacheEntry{}, nil, false
+	}
+	e.LastUsed = time.Now().UTC()
+	entry := *e
+	c.mu.Unlock()
+
+	f, err := os.Open(c.blobPath(entry.SHA256))
+	if err == nil {
+		var info os.FileInfo
+		if info, err = f.Stat(); err == nil && (info.Size() != entry.Size || !info.ModTime().Equal(entry.ModTime)) {
+			err = errors.New("the file changed on disk since its checksum was verified")
+		}
+		if err != nil {
+			f.Close()
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		log.Printf("Dropping cached %s: %s", srn, err)
+		c.stats.Corrupted++
+		if current, ok := c.entries[srn]; ok && current.SHA256 == entry.SHA256 {
+			c.remove(current)
+			c.write()
+		}
+		return cacheEntry{}, nil, false
+	}
+	c.stats.Hits++
+	return entry, f, true
+}
+
+// downloads the file into a temporary file and moves it under its checksum
+func (c *fetchCache) fill(srn string, fill func(w io.Writer) (cacheEntry, error)) (cacheEntry, *os.File, error) {
+
+	tmp, err := ioutil.TempFile(c.dir, "fill-")
+	if err != nil {
+		return cacheEntry{}, nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(tmp, hash)}
+	entry, err := fill(counter)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return cacheEntry{}, nil, err
+	}
+
+	entry.SRN = srn
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	entry.Size = counter.n
+	entry.LastUsed = time.Now().UTC()
+
+	if entry.Size > c.maxSize {
+		// too big to keep, the open file stays readable after it is removed
+		f, err := os.Open(tmp.Name())
+		return entry, f, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if previous, ok := c.entries[srn]; ok {
+		c.remove(previous)
+	}
+	// another SRN with the same content has the file already, the copy is dropped
+	if c.blobs[entry.SHA256] == 0 {
+		if err := os.Rename(tmp.Name(), c.blobPath(entry.SHA256)); err != nil {
+			return cacheEntry{}, nil, err
+		}
+	}
+	info, err := os.Stat(c.blobPath(entry.SHA256))
+	if err != nil {
+		return cacheEntry{}, nil, err
+	}
+	entry.ModTime = info.ModTime()
+	c.add(&entry)
+	c.evict(srn)
+	if err := c.write(); err != nil {
+		log.Printf("Error writing cache index: %s", err)
+	}
+
+	f, err := os.Open(c.blobPath(entry.SHA256))
+	return entry, f, err
+}
+
+// returns the SHA-256 of a file in hex
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// returns the counters and current size of the cache
+func (c *fetchCache) Stats() cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries, stats.Size, stats.MaxSize = len(c.entries), c.size, c.maxSize
+	return stats
+}
+
+func (c *fetchCache) blobPath(sum string) string {
+	return filepath.Join(c.dir, sum)
+}
+
+// adds an entry, the size grows only for content not cached yet; mu must be held
+// except while the cache is being opened
+func (c *fetchCache) add(e *cacheEntry) {
+	if c.blobs[e.SHA256] == 0 {
+		c.size += e.Size
+	}
+	c.blobs[e.SHA256]++
+	c.entries[e.SRN] = e
+}
+
+// removes an entry and its file once no other SRN uses it; mu must be held
+func (c *fetchCache) remove(e *cacheEntry) {
+	delete(c.entries, e.SRN)
+	if c.blobs[e.SHA256]--; c.blobs[e.SHA256] > 0 {
+		return
+	}
+	delete(c.blobs, e.SHA256)
+	c.size -= e.Size
+	os.Remove(c.blobPath(e.SHA256))
+}
+
+// evicts the least recently used entries until the cache fits, keep is never evicted; mu must be held
+func (c *fetchCache) evict(keep string) {
+
+	if c.size <= c.maxSize {
+		return
+	}
+	lru := make([]*cacheEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		if e.SRN != keep {
+			lru = append(lru, e)
+		}
+	}
+	sort.Slice(lru, func(i, j int) bool { return lru[i].LastUsed.Before(lru[j].LastUsed) })
+
+	for _, e := range lru {
+		if c.size <= c.maxSize {
+			break
+		}
+		log.Printf("Evicting cached %s (%d bytes)", e.SRN, e.Size)
+		c.remove(e)
+		c.stats.Evictions++
+	}
+}
+
+// writes the index to a temporary file and renames it over the old one; mu must be held
+func (c *fetchCache) write() error {
+
+	list := make([]*cacheEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].SRN < list[j].SRN })
+
+	data, err := json.MarshalIndent(list, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(c.dir, cacheIndexName)
+	tmp, err := ioutil.TempFile(c.dir, cacheIndexName+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+/*
+	Function serves a single versioned SRN from the cache, resolving and
+	downloading it on a miss; cached files support ranges and conditional
+	requests on their checksum through http.ServeContent
+*/
+func serveCached(w http.ResponseWriter, r *http.Request, fileReq FileRequest, job *fetchJob) {
+
+	SRN := fileReq.SRNS[0]
+
+	// the download fills the cache for every waiting request,
+	// so it is not cancelled when the first client goes away
+	entry, f, err := fetchFiles.Get(SRN, func(dst io.Writer) (cacheEntry, error) {
+		return downloadFile(context.Background(), dst, fileReq, job)
+	})
+	if err != nil {
+		writeDownloadError(w, SRN, err)
+		return
+	}
+	defer f.Close()
+
+	setFileHeaders(w, SRN, FileLocation{Key: entry.Filename}, bufio.NewReaderSize(f, sniffLen))
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		writeFetchError(w, http.StatusInternalServerError, "Error reading cached file")
+		return
+	}
+	// the file was checked against its checksum when it was downloaded,
+	// a hit only checks its size and modification time did not change
+	setServedFromHeaders(w, entry.Region, entry.EndPoint)
+	w.Header().Set("ETag", `"`+entry.SHA256+`"`)
+	w.Header().Set("X-Checksum-Sha256", entry.SHA256)
+	if entry.MD5 != "" {
+		w.Header().Set("X-Checksum-Md5", entry.MD5)
+	}
+	if entry.Checksum != "" {
+		w.Header().Set("X-Checksum-Status", entry.Checksum)
+	}
+	http.ServeContent(w, r, entry.Filename, time.Time{}, f)
+}
+
+/*
+	Function resolves the SRN with Delivery API and copies the file to dst,
+	reporting the download as progress of the job and checking it against its
+	checksum; failures are returned as a fetchError carrying the status for
+	the client, a mismatch is one unless fetchVerify only flags it
+*/
+func downloadFile(ctx context.Context, dst io.Writer, fileReq FileRequest, job *fetchJob) (cacheEntry, error) {
+
+	SRN := fileReq.SRNS[0]
+	delivery, err := postGetResources(fileReq)
+	if err != nil {
+		return cacheEntry{}, fetchError{SRN: SRN, Status: http.StatusBadGateway, Reason: "Delivery request failed: " + err.Error()}
+	}
+	if unresolved := delivery.Unresolved(fileReq.SRNS); len(unresolved) > 0 {
+		return cacheEntry{}, unresolved[0]
+	}
+
+	src := newFileSource(delivery.ResultsBySRN()[SRN], fileReq.TargetRegionID)
+	obj, _, err := openChunked(ctx, src, fetchTuning)
+	if err != nil {
+		return cacheEntry{}, fetchError{SRN: SRN, Status: storageErrorStatus(err), Reason: storageErrorReason(err)}
+	}
+	defer obj.Body.Close()
+
+	job.SetPhase(phaseDownloading)
+	job.SetTotal(obj.Size)
+	verify := newVerifier(expectedChecksum(src.Result(), obj))
+	if _, err := io.CopyBuffer(io.MultiWriter(job.Writer(dst), verify), obj.Body, make([]byte, streamBufferSize)); err != nil {
+		return cacheEntry{}, err
+	}
+
+	entry := cacheEntry{Filename: fileNameFor(SRN, src.Result().FileLocation), MD5: verify.MD5()}
+	entry.Region, entry.EndPoint = src.Result().FileLocation.servedFrom()
+	if fetchVerify != verifyOff {
+		entry.Checksum = verify.Status()
+	}
+	if entry.Checksum == checksumMismatch {
+		log.Printf("Checksum mismatch for %s: expected %s %x from %s, got md5 %s",
+			SRN, verify.expected.Algorithm, verify.expected.Sum, verify.expected.Source, entry.MD5)
+		if fetchVerify == verifyFail {
+			return cacheEntry{}, fetchError{SRN: SRN, Status: http.StatusBadGateway, Reason: errChecksumMismatch.Error()}
+		}
+	}
+	return entry, nil
+}
+
+// writes the error of a failed downloadFile, with the status a fetchError carries or a 502
+func writeDownloadError(w http.ResponseWriter, SRN string, err error) {
+	log.Printf("Error fetching %s: %s", SRN, redactSecrets(err.Error()))
+	var e fetchError
+	if errors.As(err, &e) {
+		writeFetchError(w, e.Status, "Error fetching file", e)
+		return
+	}
+	writeFetchError(w, http.StatusBadGateway, "Error fetching file",
+		fetchError{SRN: SRN, Status: http.StatusBadGateway, Reason: storageErrorReason(err)})
+}
+
+/*
+	Function reads a whole file into memory for the endpoints that parse it,
+	through the cache when it is on; files bigger than maxSize are refused
+*/
+func readFile(ctx context.Context, fileReq FileRequest, maxSize int64) ([]byte, cacheEntry, error) {
+
+	SRN := fileReq.SRNS[0]
+	tooBig := fetchError{SRN: SRN, Status: http.StatusRequestEntityTooLarge,
+		Reason: fmt.Sprintf("file is larger than %d MB", maxSize>>20)}
+
+	// nobody follows the progress of these downloads
+	job := &fetchJob{}
+
+	if fetchFiles != nil && fetchFiles.Cacheable(SRN) {
+		entry, f, err := fetchFiles.Get(SRN, func(dst io.Writer) (cacheEntry, error) {
+			return downloadFile(context.Background(), dst, fileReq, job)
+		})
+		if err != nil {
+			return nil, cacheEntry{}, err
+		}
+		defer f.Close()
+		if entry.Size > maxSize {
+			return nil, cacheEntry{}, tooBig
+		}
+		data, err := ioutil.ReadAll(f)
+		return data, entry, err
+	}
+
+	var buf bytes.Buffer
+	entry, err := downloadFile(ctx, &limitedWriter{w: &buf, n: maxSize}, fileReq, job)
+	if err == errFileTooBig {
+		return nil, cacheEntry{}, tooBig
+	}
+	return buf.Bytes(), entry, err
+}
+
+// errFileTooBig is returned by a limitedWriter that is full
+var errFileTooBig = errors.New("file too big")
+
+// limitedWriter takes at most n bytes
+type limitedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.n {
+		return 0, errFileTooBig
+	}
+	l.n -= int64(len(p))
+	return l.w.Write(p)
+}
+
+// Cache handler reports the cache counters as JSON
+func handleFetchCache(w http.ResponseWriter, r *http.Request) {
+	if fetchFiles == nil {
+		writeFetchError(w, http.StatusNotFound, "fetch cache is disabled, set OSDU_FETCH_CACHE_DIR")
+		return
+	}
+	writeJSON(w, http.StatusOK, fetchFiles.Stats())
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// reads a file the cache returned and closes it
+func readCached(t *testing.T, f *os.File) string {
+	t.Helper()
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestFetchCacheVerifiesOnFill(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "fetch-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache, err := openFetchCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fills := 0
+	fill := func(w io.Writer) (cacheEntry, error) {
+		fills++
+		_, err := io.Copy(w, strings.NewReader("MD,INC,AZI\n0,0,0\n"))
+		return cacheEntry{Filename: "8438.csv"}, err
+	}
+	const srn = "srn:file/csv:6dd13750df8611e9b5df4fa704076d5c:1"
+
+	for i := 0; i < 2; i++ {
+		entry, f, err := cache.Get(srn, fill)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data := readCached(t, f); data != "MD,INC,AZI\n0,0,0\n" || entry.ModTime.IsZero() {
+			t.Errorf("get %d: %q, modified %v", i, data, entry.ModTime)
+		}
+	}
+	// another SRN with the same content shares the file and keeps it verified
+	if _, f, err := cache.Get("srn:file/csv:8438:1", fill); err != nil {
+		t.Fatal(err)
+	} else {
+		f.Close()
+	}
+	if _, f, err := cache.Get(srn, fill); err != nil || fills != 2 {
+		t.Fatalf("%d fills after a shared file, %v", fills, err)
+	} else {
+		f.Close()
+	}
+
+	// a file that changed on disk is dropped and downloaded again
+	entry := *cache.entries[srn]
+	later := entry.ModTime.Add(time.Minute)
+	if err := os.Chtimes(cache.blobPath(entry.SHA256), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, f, err := cache.Get(srn, fill); err != nil || fills != 3 {
+		t.Fatalf("%d fills after the file changed, %v", fills, err)
+	} else {
+		f.Close()
+	}
+	if stats := cache.Stats(); stats.Corrupted != 1 || stats.Hits != 2 {
+		t.Errorf("stats %+v", stats)
+	}
+
+	// the next run verifies files whose modification time moved and keeps them when they are intact
+	entry = *cache.entries[srn]
+	if err := os.Chtimes(cache.blobPath(entry.SHA256), later, later); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := openFetchCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, f, err := reopened.Get(srn, fill); err != nil || fills != 3 {
+		t.Fatalf("%d fills after reopening, %v", fills, err)
+	} else {
+		f.Close()
+	}
+}
+
+// a file failing its checksum is not cached when fetchVerify is fail, and flagged when it is flag
+func TestFetchCacheChecksumMismatch(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "fetch-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache, err := openFetchCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const SRN = "srn:file/csv:6dd13750df8611e9b5df4fa704076d5c:1"
+	blobs := newStandIn(map[string][]byte{"/tno/8438.csv": []byte("MD,INC,AZI\n0,0,0\n")})
+	defer blobs.Close()
+	delivery := newDeliveryStandIn(DeliveryResult{SRN: SRN, Checksum: md5Hex("changed"),
+		FileLocation: FileLocation{EndPoint: blobs.URL, Key: "/tno/8438.csv"}})
+	defer delivery.Close()
+	defer func(base, verify string) { clientAPIBaseURL, fetchVerify = base, verify }(clientAPIBaseURL, fetchVerify)
+	clientAPIBaseURL = delivery.URL
+
+	fill := func(w io.Writer) (cacheEntry, error) {
+		return downloadFile(context.Background(), w, FileRequest{SRNS: []string{SRN}}, &fetchJob{})
+	}
+
+	fetchVerify = verifyFail
+	_, _, err = cache.Get(SRN, fill)
+	if e, ok := err.(fetchError); !ok || e.Status != http.StatusBadGateway || e.Reason != errChecksumMismatch.Error() {
+		t.Fatalf("error %v, want a checksum mismatch", err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Size != 0 || len(files) != 0 {
+		t.Errorf("a mismatching file was kept: %+v, %d files", stats, len(files))
+	}
+
+	fetchVerify = verifyFlag
+	entry, f, err := cache.Get(SRN, fill)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := readCached(t, f); data != "MD,INC,AZI\n0,0,0\n" || entry.Checksum != checksumMismatch {
+		t.Errorf("flagged file %q with status %q", data, entry.Checksum)
+	}
+}
+
+// the least recently used files go when the cache grows over its limit, a file bigger than the limit is not kept
+func TestFetchCacheEviction(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "fetch-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache, err := openFetchCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(srn, content string) {
+		t.Helper()
+		_, f, err := cache.Get(srn, func(w io.Writer) (cacheEntry, error) {
+			_, err := io.WriteString(w, content)
+			return cacheEntry{Filename: srn}, err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data := readCached(t, f); data != content {
+			t.Errorf("%s: %q, want %q", srn, data, content)
+		}
+	}
+
+	get("srn:file/csv:a:1", "aaaa")
+	get("srn:file/csv:b:1", "bbbb")
+	get("srn:file/csv:a:1", "aaaa") // a is used after b
+	get("srn:file/csv:c:1", "cccc")
+	if _, ok := cache.entries["srn:file/csv:b:1"]; ok || len(cache.entries) != 2 {
+		t.Errorf("entries after eviction %v", cache.entries)
+	}
+	if _, err := os.Stat(cache.blobPath(cache.entries["srn:file/csv:a:1"].SHA256)); err != nil {
+		t.Error(err)
+	}
+	if stats := cache.Stats(); stats.Size != 8 || stats.Evictions != 1 || stats.Hits != 1 || stats.Misses != 3 {
+		t.Errorf("stats %+v", stats)
+	}
+
+	get("srn:file/csv:big:1", "0123456789ab")
+	if _, ok := cache.entries["srn:file/csv:big:1"]; ok || cache.Stats().Size != 8 {
+		t.Errorf("kept a file bigger than the cache: %+v", cache.Stats())
+	}
+}
+
+// concurrent requests for the same SRN wait for a single download
+func TestFetchCacheSharedFill(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "fetch-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache, err := openFetchCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const srn = "srn:file/csv:6dd13750df8611e9b5df4fa704076d5c:1"
+	const requests = 5
+	var fills int32
+	started, release := make(chan struct{}), make(chan struct{})
+	fill := func(w io.Writer) (cacheEntry, error) {
+		if atomic.AddInt32(&fills, 1) == 1 {
+			close(started)
+		}
+		<-release
+		_, err := io.WriteString(w, "MD,INC,AZI\n0,0,0\n")
+		return cacheEntry{Filename: "8438.csv"}, err
+	}
+
+	results := make(chan string, requests)
+	get := func() {
+		_, f, err := cache.Get(srn, fill)
+		if err != nil {
+			results <- err.Error()
+			return
+		}
+		defer f.Close()
+		data, _ := ioutil.ReadAll(f)
+		results <- string(data)
+	}
+
+	go get()
+	<-started
+	for i := 1; i < requests; i++ {
+		go get()
+	}
+	// the others wait on the running fill before it is let go
+	for deadline := time.Now().Add(5 * time.Second); cache.Stats().Shared < requests-1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests share the fill", cache.Stats().Shared)
+		}
+	}
+	close(release)
+
+	for i := 0; i < requests; i++ {
+		if data := <-results; data != "MD,INC,AZI\n0,0,0\n" {
+			t.Errorf("request got %q", data)
+		}
+	}
+	if fills != 1 {
+		t.Errorf("%d fills for %d concurrent requests", fills, requests)
+	}
+	if stats := cache.Stats(); stats.Misses != 1 || stats.Shared != requests-1 {
+		t.Errorf("stats %+v", stats)
+	}
+}
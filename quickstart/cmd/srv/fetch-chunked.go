@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"strconv"
+
+	"golang.org/x/net/context"
+)
+
+// limits for block sizes picked from the file size
+const (
+	minBlockSize = 1 << 20
+	maxBlockSize = 32 << 20
+)
+
+// files smaller than this are read with a single request
+const minChunkedSize = 8 << 20
+
+// attempts made for every block before the download fails
+const blockAttempts = 3
+
+// block size and parallelism of full file downloads, set from
+// OSDU_FETCH_BLOCK_SIZE and OSDU_FETCH_PARALLELISM in main
+var fetchTuning chunkTuning
+
+// chunkTuning sets how files are split into blocks, zero values are picked from the file size
+type chunkTuning struct {
+	BlockSize   int64
+	Parallelism int
+}
+
+// parses the tuning from environment values, empty or "0" means automatic
+func parseChunkTuning(blockSize, parallelism string) (chunkTuning, error) {
+	var t chunkTuning
+	var err error
+	if blockSize != "" {
+		if t.BlockSize, err = strconv.ParseInt(blockSize, 10, 64); err != nil || t.BlockSize < 0 {
+			return t, errors.New("block size must be a number of bytes")
+		}
+	}
+	if parallelism != "" {
+		if t.Parallelism, err = strconv.Atoi(parallelism); err != nil || t.Parallelism < 0 {
+			return t, errors.New("parallelism must be a positive number")
+		}
+	}
+	return t, nil
+}
+
+/*
+	Function picks block size and parallelism for a file: small files are
+	read with one request, larger ones with 4 and from 256MB with 8 parallel
+	requests, blocks are sized to give every request about 4 of them
+*/
+func (t chunkTuning) Plan(size int64) (int64, int) {
+
+	parallelism := t.Parallelism
+	if parallelism == 0 {
+		switch {
+		case size < minChunkedSize:
+			parallelism = 1
+		case size < 256<<20:
+			parallelism = 4
+		default:
+			parallelism = 8
+		}
+	}
+
+	blockSize := t.BlockSize
+	if blockSize == 0 {
+		blockSize = size / int64(parallelism*4)
+		if blockSize < minBlockSize {
+			blockSize = minBlockSize
+		}
+		if blockSize > maxBlockSize {
+			blockSize = maxBlockSize
+		}
+	}
+
+	return blockSize, parallelism
+}
+
+/*
+	Function opens the whole file for reading in blocks fetched in parallel
+	with range requests; the first block tells the file size, the rest are
+	downloaded by a pool of workers and written to the returned body in order,
+	holding at most one buffer per worker in memory. Files that fit into the
+	first block or are read with parallelism 1 need one or two plain requests
+*/
+func openChunked(ctx context.Context, src *fileSource, tuning chunkTuning) (*storageObject, storageBackend, error) {
+
+	probe := tuning.BlockSize
+	if probe == 0 {
+		probe = minBlockSize
+	}
+
+	first, backend, err := src.Open(ctx, byteRange{Count: probe})
+	if err == errRangeNotSatisfiable {
+		// an empty file has no first byte to ask for
+		return src.Open(ctx, byteRange{})
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	total := first.TotalSize
+	if first.Offset != 0 || total < 0 || first.Size >= total {
+		if total < 0 {
+			first.Body.Close()
+			return src.Open(ctx, byteRange{})
+		}
+		return first, backend, nil
+	}
+
+	blockSize, parallelism := tuning.Plan(total)
+	if parallelism <= 1 {
+		rest, _, err := src.Open(ctx, byteRange{Offset: first.Size})
+		if err != nil {
+			first.Body.Close()
+			return nil, nil, err
+		}
+		body := struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(first.Body, rest.Body), closers{first.Body, rest.Body}}
+		return &storageObject{Body: body, Size: total, TotalSize: total, MD5: first.MD5}, backend, nil
+	}
+
+	log.Printf("Downloading %d bytes in %d byte blocks, %d in parallel (%s)", total, blockSize, parallelism, backend.Name())
+
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(copyBlocks(ctx, pw, src, first, blockSize, parallelism))
+	}()
+
+	body := struct {
+		io.Reader
+		io.Closer
+	}{pr, closeFunc(func() error {
+		cancel()
+		return pr.Close()
+	})}
+	return &storageObject{Body: body, Size: total, TotalSize: total, MD5: first.MD5}, backend, nil
+}
+
+// a block of the file handed to a worker, data is filled in before it is sent on done
+type block struct {
+	offset int64
+	data   []byte
+	err    error
+	done   chan *block
+}
+
+/*
+	Function writes the first block and then the rest of the file to w in
+	order, blocks are dispatched in order and every worker reuses one of the
+	parallelism buffers, which is given back once its block is written
+*/
+func copyBlocks(ctx context.Context, w io.Writer, src *fileSource, first *storageObject, blockSize int64, parallelism int) error {
+
+	_, err := io.CopyBuffer(w, first.Body, make([]byte, streamBufferSize))
+	first.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	total := first.TotalSize
+	buffers := make(chan []byte, parallelism)
+	for i := 0; i < parallelism; i++ {
+		buffers <- make([]byte, blockSize)
+	}
+
+	jobs := make(chan *block)
+	ordered := make(chan *block, parallelism)
+	for i := 0; i < parallelism; i++ {
+		go func() {
+			for b := range jobs {
+				b.err = readBlock(ctx, src, b.offset, b.data)
+				b.done <- b
+			}
+		}()
+	}
+
+	// dispatch blocks in order, waiting for a free buffer each time
+	go func() {
+		defer close(jobs)
+		defer close(ordered)
+		for offset := first.Size; offset < total; offset += blockSize {
+			var buf []byte
+			select {
+			case buf = <-buffers:
+			case <-ctx.Done():
+				return
+			}
+			size := blockSize
+			if offset+size > total {
+				size = total - offset
+			}
+			b := &block{offset: offset, data: buf[:size], done: make(chan *block, 1)}
+			select {
+			case jobs <- b:
+				ordered <- b
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for b := range ordered {
+		b = <-b.done
+		if b.err != nil {
+			return b.err
+		}
+		if _, err := w.Write(b.data); err != nil {
+			return err
+		}
+		buffers <- b.data[:cap(b.data)]
+	}
+	return ctx.Err()
+}
+
+// reads one block into buf, retrying a failed or short read
+func readBlock(ctx context.Context, src *fileSource, offset int64, buf []byte) error {
+
+	var err error
+	for attempt := 1; attempt <= blockAttempts; attempt++ {
+		var obj *storageObject
+		obj, _, err = src.Open(ctx, byteRange{Offset: offset, Count: int64(len(buf))})
+		if err == nil {
+			_, err = io.ReadFull(obj.Body, buf)
+			obj.Body.Close()
+		}
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		log.Printf("Block at %d failed (attempt %d of %d): %s", offset, attempt, blockAttempts, storageErrorReason(err))
+	}
+	return err
+}
+
+// closers closes all of them, returning the first error
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	var first error
+	for _, c := range cs {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// closeFunc turns a function into an io.Closer
+type closeFunc func() error
+
+func (f closeFunc) Close() error { return f() }
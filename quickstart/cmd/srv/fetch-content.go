@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// content types for the file kinds in the SRN type segment, e.g. srn:file/csv:...
+var srnContentTypes = map[string]string{
+	"csv":  "text/csv; charset=utf-8",
+	"las2": "application/x-las",
+	"las3": "application/x-las",
+	"json": "application/json",
+	"pdf":  "application/pdf",
+	"zip":  "application/zip",
+	"segy": "application/octet-stream",
+	"dlis": "application/octet-stream",
+}
+
+// content types for file extensions the mime package does not know
+var extContentTypes = map[string]string{
+	".csv": "text/csv; charset=utf-8",
+	".las": "application/x-las",
+	".sgy": "application/octet-stream",
+}
+
+// extensions given to files named after their SRN
+var srnExtensions = map[string]string{
+	"csv":  ".csv",
+	"las2": ".las",
+	"las3": ".las",
+	"json": ".json",
+	"pdf":  ".pdf",
+	"zip":  ".zip",
+	"segy": ".sgy",
+	"dlis": ".dlis",
+}
+
+// number of bytes looked at when the content type has to be sniffed
+const sniffLen = 512
+
+// returns the file kind in the SRN type segment, "csv" for srn:file/csv:<id>:1
+func srnFileType(srn string) string {
+	parts := strings.SplitN(srn, ":", 3)
+	if len(parts) < 2 {
+		return ""
+	}
+	kind := strings.SplitN(parts[1], "/", 2)
+	if len(kind) < 2 {
+		return ""
+	}
+	return strings.ToLower(kind[1])
+}
+
+/*
+	Function names the file for the client: the last element of the blob key
+	(e.g. 8438.csv), or the SRN made file name safe with an extension for
+	its type when the key has no usable name
+*/
+func fileNameFor(srn string, loc FileLocation) string {
+	name := path.Base(loc.Key)
+	if name != "." && name != "/" && name != "" {
+		return name
+	}
+	return strings.NewReplacer(":", "_", "/", "_").Replace(strings.TrimSuffix(srn, ":")) + srnExtensions[srnFileType(srn)]
+}
+
+// picks the content type from the SRN type, then the file name, empty when unknown
+func contentTypeFor(srn, name string) string {
+	if contentType, ok := srnContentTypes[srnFileType(srn)]; ok {
+		return contentType
+	}
+	ext := strings.ToLower(path.Ext(name))
+	if contentType, ok := extContentTypes[ext]; ok {
+		return contentType
+	}
+	return mime.TypeByExtension(ext)
+}
+
+/*
+	Function sets Content-Type and Content-Disposition for a fetched file,
+	the content type is sniffed from the first bytes of body when neither the
+	SRN nor the file name tell it, so body must be what is copied to the client
+*/
+func setFileHeaders(w http.ResponseWriter, srn string, loc FileLocation, body *bufio.Reader) {
+
+	name := fileNameFor(srn, loc)
+	contentType := contentTypeFor(srn, name)
+	if contentType == "" {
+		// Peek returns what it could read when the file is shorter
+		head, _ := body.Peek(sniffLen)
+		contentType = http.DetectContentType(head)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// how /fetch hands a file to the client
+const (
+	modeProxy    = "proxy"    // bytes are streamed through this server
+	modeRedirect = "redirect" // 302 to the storage URL
+	modeURL      = "url"      // JSON with the storage URL and its expiry
+)
+
+// modes clients may ask for, set from OSDU_FETCH_MODES in main; storage
+// URLs carry their credentials, so only proxying is allowed by default
+var allowedFetchModes = map[string]bool{modeProxy: true}
+
+// parses a comma separated list of fetch modes
+func parseFetchModes(list string) (map[string]bool, error) {
+	modes := map[string]bool{}
+	for _, mode := range strings.Split(list, ",") {
+		mode = strings.ToLower(strings.TrimSpace(mode))
+		switch mode {
+		case "":
+		case modeProxy, modeRedirect, modeURL:
+			modes[mode] = true
+		default:
+			return nil, fmt.Errorf("unknown fetch mode %q, use proxy, redirect or url", mode)
+		}
+	}
+	if len(modes) == 0 {
+		return nil, fmt.Errorf("at least one fetch mode must be allowed")
+	}
+	return modes, nil
+}
+
+// fileURL is a storage URL of a resolved SRN
+type fileURL struct {
+	SRN      string     `json:"srn"`
+	Filename string     `json:"filename"`
+	URL      string     `json:"url"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	Region   string     `json:"region,omitempty"`
+	EndPoint string     `json:"endpoint,omitempty"`
+}
+
+// fileURLResponse is the body of /fetch?mode=url
+type fileURLResponse struct {
+	Files  []fileURL    `json:"files"`
+	Errors []fetchError `json:"errors,omitempty"`
+}
+
+// returns the storage URL for a resolved SRN with the time it expires and where it is served from
+func storageURL(result DeliveryResult) (fileURL, error) {
+
+	backend, err := resolveBackend(result.FileLocation)
+	if err != nil {
+		return fileURL{}, err
+	}
+	u, err := backend.URL(result.FileLocation)
+	if err != nil {
+		return fileURL{}, err
+	}
+	file := fileURL{
+		SRN:      result.SRN,
+		Filename: fileNameFor(result.SRN, result.FileLocation),
+		URL:      u,
+		Expires:  urlExpiry(u, result.FileLocation.TemporaryCredentials),
+	}
+	file.Region, file.EndPoint = result.FileLocation.servedFrom()
+	return file, nil
+}
+
+/*
+	Function answers /fetch?mode=redirect and mode=url: instead of proxying
+	the bytes the client gets the storage URL, as a 302 for a single SRN or
+	as JSON listing the URL and expiry of every SRN
+*/
+func writeStorageURLs(w http.ResponseWriter, r *http.Request, mode string, fileReq FileRequest) {
+
+	if mode == modeRedirect && len(fileReq.SRNS) != 1 {
+		writeFetchError(w, http.StatusBadRequest, "mode=redirect takes a single srn, use mode=url for several")
+		return
+	}
+
+	delivery, err := postGetResources(fileReq)
+	if err != nil {
+		log.Printf("HTTP request failed with %s", err)
+		writeFetchError(w, http.StatusBadGateway, "Delivery request failed: "+err.Error())
+		return
+	}
+
+	var response fileURLResponse
+	response.Errors = delivery.Unresolved(fileReq.SRNS)
+	bySRN := delivery.ResultsBySRN()
+	for _, srn := range fileReq.SRNS {
+		result, ok := bySRN[srn]
+		if !ok {
+			continue
+		}
+		file, err := storageURL(result)
+		if err != nil {
+			response.Errors = append(response.Errors, fetchError{SRN: srn, Status: http.StatusBadGateway, Reason: storageErrorReason(err)})
+			continue
+		}
+		log.Printf("Handing out storage URL for %s: %s", srn, redactSecrets(file.URL))
+		response.Files = append(response.Files, file)
+	}
+
+	if len(response.Files) == 0 {
+		status := http.StatusNotFound
+		for _, e := range response.Errors {
+			if e.Status != http.StatusNotFound {
+				status = http.StatusBadGateway
+			}
+		}
+		writeFetchError(w, status, "None of the SRNs could be fetched", response.Errors...)
+		return
+	}
+
+	if len(fileReq.SRNS) == 1 {
+		setServedFromHeaders(w, response.Files[0].Region, response.Files[0].EndPoint)
+	}
+	if mode == modeRedirect {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, response.Files[0].URL, http.StatusFound)
+		return
+	}
+
+	status := http.StatusOK
+	if len(response.Errors) > 0 {
+		status = http.StatusMultiStatus
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, response)
+}
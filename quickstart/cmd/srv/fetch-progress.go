@@ -0,0 +1,291 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// phases of a fetch job as reported on /fetch/progress/{id}
+const (
+	phaseResolving   = "resolving"
+	phaseDownloading = "downloading"
+	phaseSending     = "sending"
+	phaseDone        = "done"
+	phaseFailed      = "failed"
+)
+
+// how often progress events are sent and how long finished jobs are kept
+const (
+	progressInterval = 500 * time.Millisecond
+	finishedJobTTL   = 5 * time.Minute
+	unknownJobWait   = 10 * time.Second
+)
+
+// job IDs a client may pick itself, so it can subscribe before the download starts
+var jobIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// running and recently finished fetch jobs
+var fetchJobs = &jobRegistry{jobs: map[string]*fetchJob{}}
+
+// fetchJob tracks one /fetch request from resolving the SRNs to the last byte sent
+type fetchJob struct {
+	ID   string
+	SRNs []string
+
+	mu          sync.Mutex
+	phase       string
+	transferred int64
+	total       int64
+	phaseStart  time.Time
+	err         string
+}
+
+// progressEvent is the data of every event on /fetch/progress/{id}
+type progressEvent struct {
+	ID          string   `json:"id"`
+	SRNs        []string `json:"srns"`
+	Phase       string   `json:"phase"`
+	Transferred int64    `json:"transferred"`
+	Total       int64    `json:"total,omitempty"`
+	Rate        float64  `json:"rate"`          // bytes per second
+	ETA         *float64 `json:"eta,omitempty"` // seconds, when the total is known
+	Error       string   `json:"error,omitempty"`
+}
+
+// starts a new phase, counting bytes from zero
+func (j *fetchJob) SetPhase(phase string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.phase, j.transferred, j.total, j.phaseStart = phase, 0, 0, time.Now()
+}
+
+// sets the number of bytes the current phase transfers
+func (j *fetchJob) SetTotal(total int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.total = total
+}
+
+// adds to the number of bytes the current phase transfers, for phases made of several files
+func (j *fetchJob) AddTotal(n int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.total += n
+}
+
+// counts transferred bytes, what is sent after the job finished (an error body) is not counted
+func (j *fetchJob) Add(n int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.phase != phaseDone && j.phase != phaseFailed {
+		j.transferred += n
+	}
+}
+
+// marks the job done, or failed when err is not empty
+func (j *fetchJob) Finish(err string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.phase == phaseDone || j.phase == phaseFailed {
+		return
+	}
+	j.phase, j.err = phaseDone, err
+	if err != "" {
+		j.phase = phaseFailed
+	}
+}
+
+// reports whether the job is done or failed
+func (j *fetchJob) Finished() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.phase == phaseDone || j.phase == phaseFailed
+}
+
+// returns the current progress with the average rate of the phase and the time left
+func (j *fetchJob) Progress() progressEvent {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	event := progressEvent{ID: j.ID, SRNs: j.SRNs, Phase: j.phase, Transferred: j.transferred, Total: j.total, Error: j.err}
+	if elapsed := time.Since(j.phaseStart).Seconds(); elapsed > 0 {
+		event.Rate = float64(j.transferred) / elapsed
+	}
+	if j.total > 0 && event.Rate > 0 && j.phase != phaseDone && j.phase != phaseFailed {
+		eta := float64(j.total-j.transferred) / event.Rate
+		event.ETA = &eta
+	}
+	return event
+}
+
+// returns a writer counting the bytes written to w as progress of the job
+func (j *fetchJob) Writer(w io.Writer) io.Writer {
+	return &progressCounter{w: w, job: j}
+}
+
+type progressCounter struct {
+	w   io.Writer
+	job *fetchJob
+}
+
+func (p *progressCounter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.job.Add(int64(n))
+	return n, err
+}
+
+/*
+	progressWriter counts what the fetch handler sends to the client: the
+	status line moves the job to sending with Content-Length as the total,
+	an error status fails it
+*/
+type progressWriter struct {
+	http.ResponseWriter
+	job         *fetchJob
+	wroteHeader bool
+}
+
+func (p *progressWriter) WriteHeader(status int) {
+	if !p.wroteHeader {
+		p.wroteHeader = true
+		if status >= http.StatusBadRequest {
+			p.job.Finish(http.StatusText(status))
+		} else {
+			p.job.SetPhase(phaseSending)
+			if size, err := strconv.ParseInt(p.Header().Get("Content-Length"), 10, 64); err == nil {
+				p.job.SetTotal(size)
+			}
+		}
+	}
+	p.ResponseWriter.WriteHeader(status)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	if !p.wroteHeader {
+		p.WriteHeader(http.StatusOK)
+	}
+	n, err := p.ResponseWriter.Write(b)
+	p.job.Add(int64(n))
+	return n, err
+}
+
+// jobRegistry keeps jobs by ID until a while after they finish
+type jobRegistry struct {
+	mu   sync.Mutex
+	jobs map[string]*fetchJob
+}
+
+// starts a job under the ID the client picked, or a random one when id is empty
+func (r *jobRegistry) Start(id string, SRNs []string) (*fetchJob, error) {
+
+	if id == "" {
+		buf := make([]byte, 8)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		id = hex.EncodeToString(buf)
+	} else if !jobIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("job id must match %s", jobIDPattern)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.jobs[id]; ok {
+		return nil, fmt.Errorf("job %s already exists", id)
+	}
+	job := &fetchJob{ID: id, SRNs: SRNs, phase: phaseResolving, phaseStart: time.Now()}
+	r.jobs[id] = job
+	return job, nil
+}
+
+func (r *jobRegistry) Get(id string) (*fetchJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	return job, ok
+}
+
+// finishes the job and forgets it once clients had time to read the last event
+func (r *jobRegistry) Finish(job *fetchJob, err string) {
+	job.Finish(err)
+	time.AfterFunc(finishedJobTTL, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.jobs, job.ID)
+	})
+}
+
+/*
+	Progress handler streams the progress of a fetch job as Server-Sent
+	Events: a "progress" event every half a second while the job runs and a
+	final "done" or "failed" event. A job that is not started yet is waited
+	for a few seconds, so a client can subscribe with its own ?job= ID first:
+
+	const events = new EventSource("/fetch/progress/" + id)
+	events.addEventListener("progress", e => show(JSON.parse(e.data)))
+*/
+func handleFetchProgress(w http.ResponseWriter, r *http.Request) {
+
+	id := strings.TrimPrefix(r.URL.Path, "/fetch/progress/")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	job, ok := fetchJobs.Get(id)
+	for waited := time.Duration(0); !ok; waited += progressInterval {
+		if waited >= unknownJobWait {
+			writeFetchError(w, http.StatusNotFound, "unknown fetch job "+id)
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-r.Context().Done():
+			return
+		}
+		job, ok = fetchJobs.Get(id)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	for {
+		// read Finished first, so the last event always carries the final numbers
+		finished := job.Finished()
+		event := job.Progress()
+		name := "progress"
+		if finished {
+			name = event.Phase
+		}
+
+		data, err := json.Marshal(event)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+		flusher.Flush()
+		if finished {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
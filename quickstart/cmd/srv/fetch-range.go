@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+	Function parses the Range header of a /fetch request, only a single
+	byte range is supported (bytes=a-b, bytes=a- or bytes=-n); ok is false
+	when the header is missing, malformed or asks for several ranges, in
+	which case the whole file is sent as RFC 7233 allows
+*/
+func parseRangeHeader(header string) (rng byteRange, ok bool) {
+
+	spec := strings.TrimSpace(header)
+	if !strings.HasPrefix(spec, "bytes=") {
+		return byteRange{}, false
+	}
+	spec = strings.TrimSpace(strings.TrimPrefix(spec, "bytes="))
+	dash := strings.Index(spec, "-")
+	if dash < 0 || strings.Contains(spec, ",") {
+		return byteRange{}, false
+	}
+	first, last := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+
+	// suffix range, the last n bytes
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return byteRange{}, false
+		}
+		return byteRange{Suffix: n}, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return byteRange{}, false
+	}
+	if last == "" {
+		return byteRange{Offset: start}, true
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return byteRange{}, false
+	}
+	return byteRange{Offset: start, Count: end - start + 1}, true
+}
+
+// sets Content-Range and Content-Length for the part of the file being sent
+func setContentRange(w http.ResponseWriter, obj *storageObject) {
+	total := "*"
+	if obj.TotalSize >= 0 {
+		total = strconv.FormatInt(obj.TotalSize, 10)
+	}
+	w.Header().Set("Content-Range", "bytes "+strconv.FormatInt(obj.Offset, 10)+"-"+
+		strconv.FormatInt(obj.Offset+obj.Size-1, 10)+"/"+total)
+	w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// times an SRN is resolved again when storage rejects its credentials
+const maxReresolves = 2
+
+// times a download is resumed after the connection to storage failed
+const resumeAttempts = 3
+
+/*
+	fileSource is a resolved SRN whose location can be resolved again: the
+	SAS token or presigned URL returned by Delivery API expires, so when
+	storage answers 403 a fresh location is asked for and the read retried.
+	Bodies it opens resume from the last byte read when the connection drops
+*/
+type fileSource struct {
+	SRN string
+
+	// resolve asks Delivery API for a new location, nil when it can not be asked
+	resolve func() (DeliveryResult, error)
+
+	mu         sync.Mutex
+	result     DeliveryResult
+	generation int
+	reresolved int
+}
+
+// returns a source for the result that is resolved again with Delivery API when it expires
+func newFileSource(result DeliveryResult, targetRegionID string) *fileSource {
+	return &fileSource{
+		SRN:    result.SRN,
+		result: result,
+		resolve: func() (DeliveryResult, error) {
+			return resolveSRN(result.SRN, targetRegionID)
+		},
+	}
+}
+
+// calls Delivery API for a single SRN
+func resolveSRN(SRN, targetRegionID string) (DeliveryResult, error) {
+	delivery, err := postGetResources(FileRequest{SRNS: []string{SRN}, TargetRegionID: targetRegionID})
+	if err != nil {
+		return DeliveryResult{}, err
+	}
+	if unresolved := delivery.Unresolved([]string{SRN}); len(unresolved) > 0 {
+		return DeliveryResult{}, unresolved[0]
+	}
+	return delivery.ResultsBySRN()[SRN], nil
+}
+
+// returns the Delivery result the source currently reads from
+func (s *fileSource) Result() DeliveryResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.result
+}
+
+/*
+	Function opens the range of the file, resolving the SRN again when the
+	credentials of its location were rejected; the body resumes by itself
+	from where it stopped if reading it fails
+*/
+func (s *fileSource) Open(ctx context.Context, rng byteRange) (*storageObject, storageBackend, error) {
+
+	obj, backend, err := s.open(ctx, rng)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	end := int64(-1)
+	if obj.Size >= 0 {
+		end = obj.Offset + obj.Size
+	}
+	obj.Body = &resumableBody{ctx: ctx, src: s, body: obj.Body, offset: obj.Offset, end: end}
+	return obj, backend, nil
+}
+
+// opens the range with the current location, resolving it again on a 403
+func (s *fileSource) open(ctx context.Context, rng byteRange) (*storageObject, storageBackend, error) {
+	for {
+		s.mu.Lock()
+		loc, generation := s.result.FileLocation, s.generation
+		s.mu.Unlock()
+
+		obj, backend, err := openFile(ctx, loc, rng)
+		if err == nil || !credentialsRejected(err) {
+			return obj, backend, err
+		}
+		log.Printf("Storage rejected the credentials for %s: %s", s.SRN, storageErrorReason(err))
+		if rerr := s.reresolve(generation); rerr != nil {
+			log.Printf("Cannot resolve %s again: %s", s.SRN, redactSecrets(rerr.Error()))
+			return nil, nil, err
+		}
+	}
+}
+
+/*
+	Function asks Delivery API for a new location unless another reader has
+	done it since the location of the given generation was read; the lock is
+	held during the call so parallel readers wait for one new location
+*/
+func (s *fileSource) reresolve(generation int) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.generation != generation {
+		return nil
+	}
+	if s.resolve == nil || s.reresolved >= maxReresolves {
+		return errors.New("no more attempts to resolve the SRN")
+	}
+
+	result, err := s.resolve()
+	if err != nil {
+		return err
+	}
+	log.Printf("Resolved %s again for a fresh storage location", s.SRN)
+	s.result = result
+	s.generation++
+	s.reresolved++
+	return nil
+}
+
+// reports whether storage refused the SAS token, signature or access token
+func credentialsRejected(err error) bool {
+	return storageErrorCode(err) == http.StatusForbidden
+}
+
+// resumableBody reopens the file from the last byte read when reading fails
+type resumableBody struct {
+	ctx      context.Context
+	src      *fileSource
+	body     io.ReadCloser
+	offset   int64 // position in the file of the next byte
+	end      int64 // position after the last byte to read, -1 for the end of the file
+	attempts int
+}
+
+func (b *resumableBody) Read(p []byte) (int, error) {
+
+	for {
+		n, err := b.body.Read(p)
+		b.offset += int64(n)
+		if err == nil || err == io.EOF || b.ctx.Err() != nil || b.attempts >= resumeAttempts {
+			return n, err
+		}
+		if b.end >= 0 && b.offset >= b.end {
+			return n, io.EOF
+		}
+
+		b.attempts++
+		log.Printf("Resuming %s at byte %d (attempt %d of %d) after: %s",
+			b.src.SRN, b.offset, b.attempts, resumeAttempts, redactSecrets(err.Error()))
+		b.body.Close()
+
+		rng := byteRange{Offset: b.offset}
+		if b.end >= 0 {
+			rng.Count = b.end - b.offset
+		}
+		obj, _, openErr := b.src.open(b.ctx, rng)
+		if openErr != nil {
+			b.body = http.NoBody
+			return n, openErr
+		}
+		b.body = obj.Body
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (b *resumableBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+/*
+	flakyStorage serves one file to requests signed with the current token
+	and answers 403 to the others, as storage does once a SAS token or
+	presigned URL expired; the first drops responses stop after cut bytes
+*/
+type flakyStorage struct {
+	*httptest.Server
+	data []byte
+
+	mu     sync.Mutex
+	token  string
+	drops  int
+	cut    int
+	ranges []string // Range headers of the requests that were served
+}
+
+func newFlakyStorage(data string) *flakyStorage {
+	s := &flakyStorage{data: []byte(data), token: "fresh"}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *flakyStorage) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	if r.URL.Query().Get("sig") != s.token {
+		s.mu.Unlock()
+		http.Error(w, "signature expired", http.StatusForbidden)
+		return
+	}
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	drop := s.drops > 0
+	if drop {
+		s.drops--
+	}
+	s.mu.Unlock()
+
+	if drop {
+		w = &droppingWriter{ResponseWriter: w, left: s.cut}
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(s.data))
+}
+
+// returns the location of the file signed with the given token
+func (s *flakyStorage) location(token string) FileLocation {
+	return FileLocation{Provider: backendHTTPS, SignedURL: s.URL + "/tno/8438.csv?sig=" + token}
+}
+
+// droppingWriter sends the headers and the first left bytes, then drops the connection
+type droppingWriter struct {
+	http.ResponseWriter
+	left int
+}
+
+func (w *droppingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.left {
+		w.ResponseWriter.Write(p[:w.left])
+		w.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.left -= len(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// opens the range through the source and reads it to the end
+func readSource(src *fileSource, rng byteRange) (string, error) {
+	obj, _, err := src.Open(context.Background(), rng)
+	if err != nil {
+		return "", err
+	}
+	defer obj.Body.Close()
+	data, err := ioutil.ReadAll(obj.Body)
+	return string(data), err
+}
+
+func TestFileSourceResumes(t *testing.T) {
+
+	data := "MD,INC,AZI\n" + strings.Repeat("100,1.5,45\n", 100)
+	for _, c := range []struct {
+		name   string
+		rng    byteRange
+		drops  int
+		want   string
+		ranges []string
+		fails  bool
+	}{
+		{name: "whole file", drops: 2, want: data,
+			ranges: []string{"", "bytes=100-1110", "bytes=200-1110"}},
+		{name: "range", rng: byteRange{Offset: 11, Count: 440}, drops: 1, want: data[11:451],
+			ranges: []string{"bytes=11-450", "bytes=111-450"}},
+		{name: "too many drops", drops: resumeAttempts + 1, fails: true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			storage := newFlakyStorage(data)
+			defer storage.Close()
+			storage.drops, storage.cut = c.drops, 100
+
+			got, err := readSource(&fileSource{SRN: "srn:file/csv:8438:1", result: DeliveryResult{FileLocation: storage.location("fresh")}}, c.rng)
+			if c.fails {
+				if err == nil {
+					t.Errorf("read %d bytes after %d dropped connections", len(got), c.drops)
+				}
+				return
+			}
+			if err != nil || got != c.want {
+				t.Fatalf("read %d bytes, want %d: %v", len(got), len(c.want), err)
+			}
+			if strings.Join(storage.ranges, " ") != strings.Join(c.ranges, " ") {
+				t.Errorf("ranges %q, want %q", storage.ranges, c.ranges)
+			}
+		})
+	}
+}
+
+func TestFileSourceReresolves(t *testing.T) {
+
+	const SRN = "srn:file/csv:6dd13750df8611e9b5df4fa704076d5c:1"
+	data := "MD,INC,AZI\n" + strings.Repeat("100,1.5,45\n", 100)
+	storage := newFlakyStorage(data)
+	defer storage.Close()
+
+	// Delivery API hands out a location signed with the fresh token
+	delivery := newDeliveryStandIn(DeliveryResult{SRN: SRN, FileLocation: storage.location("fresh")})
+	defer func() { delivery.Close() }()
+	defer func(base string) { clientAPIBaseURL = base }(clientAPIBaseURL)
+	clientAPIBaseURL = delivery.URL
+
+	src := newFileSource(DeliveryResult{SRN: SRN, FileLocation: storage.location("expired")}, "")
+	if got, err := readSource(src, byteRange{}); err != nil || got != data {
+		t.Fatalf("read %d bytes: %v", len(got), err)
+	}
+	if src.Result().FileLocation.SignedURL != storage.location("fresh").SignedURL || src.reresolved != 1 {
+		t.Errorf("resolved %d times to %s", src.reresolved, src.Result().FileLocation.SignedURL)
+	}
+
+	// the token expires while the body is read, the resumed request is signed again
+	storage.drops, storage.cut = 1, 100
+	obj, _, err := src.Open(context.Background(), byteRange{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.mu.Lock()
+	storage.token = "renewed"
+	storage.mu.Unlock()
+	delivery.Close()
+	delivery = newDeliveryStandIn(DeliveryResult{SRN: SRN, FileLocation: storage.location("renewed")})
+	clientAPIBaseURL = delivery.URL
+	got, err := ioutil.ReadAll(obj.Body)
+	obj.Body.Close()
+	if err != nil || string(got) != data || src.reresolved != 2 {
+		t.Errorf("read %d bytes after resolving %d times: %v", len(got), src.reresolved, err)
+	}
+
+	// a source out of attempts reports the rejection of storage
+	storage.mu.Lock()
+	storage.token = "revoked"
+	storage.mu.Unlock()
+	_, err = readSource(src, byteRange{})
+	if storageErrorCode(err) != http.StatusForbidden || src.reresolved != maxReresolves {
+		t.Errorf("error %v after resolving %d times", err, src.reresolved)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// what /fetch does when a file does not match its checksum
+const (
+	verifyOff  = "off"  // nothing is checked
+	verifyFlag = "flag" // the file is sent, the mismatch is logged and reported
+	verifyFail = "fail" // the file is not delivered
+)
+
+// outcome of a verification as reported in X-Checksum-Status and the archive manifest
+const (
+	checksumVerified   = "verified"
+	checksumMismatch   = "mismatch"
+	checksumUnverified = "unverified" // no checksum to compare with
+)
+
+// where the expected checksum came from
+const (
+	checksumFromMetadata = "metadata"
+	checksumFromStorage  = "storage"
+)
+
+// errChecksumMismatch is returned when downloaded bytes differ from the stored object
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// checksum is the expected digest of a whole file
+type checksum struct {
+	Algorithm string // "md5" or "sha256"
+	Sum       []byte
+	Source    string
+}
+
+/*
+	Function picks the checksum a downloaded file is compared with: the one
+	in the OSDU file metadata returned with the Delivery result wins over the
+	MD5 storage keeps for the object (Azure Content-MD5, GCS x-goog-hash)
+*/
+func expectedChecksum(result DeliveryResult, obj *storageObject) *checksum {
+
+	if sum := decodeChecksum(result.Checksum); sum != nil {
+		algorithm := strings.ToLower(strings.Replace(result.ChecksumAlgorithm, "-", "", -1))
+		if algorithm == "" {
+			// guess from the length, 16 bytes for MD5 and 32 for SHA-256
+			algorithm = "md5"
+			if len(sum) == sha256.Size {
+				algorithm = "sha256"
+			}
+		}
+		if algorithm == "md5" || algorithm == "sha256" {
+			return &checksum{Algorithm: algorithm, Sum: sum, Source: checksumFromMetadata}
+		}
+	}
+
+	if obj != nil && len(obj.MD5) == md5.Size {
+		return &checksum{Algorithm: "md5", Sum: obj.MD5, Source: checksumFromStorage}
+	}
+	return nil
+}
+
+// decodes a hex or base64 checksum, nil when it is neither
+func decodeChecksum(s string) []byte {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	if sum, err := hex.DecodeString(s); err == nil && (len(sum) == md5.Size || len(sum) == sha256.Size) {
+		return sum
+	}
+	if sum, err := base64.StdEncoding.DecodeString(s); err == nil && (len(sum) == md5.Size || len(sum) == sha256.Size) {
+		return sum
+	}
+	return nil
+}
+
+// reads the MD5 of the whole object from storage response headers
+func storageMD5(h http.Header, partial bool) []byte {
+	if sum, err := base64.StdEncoding.DecodeString(h.Get("x-ms-blob-content-md5")); err == nil && len(sum) == md5.Size {
+		return sum
+	}
+	for _, value := range h["X-Goog-Hash"] {
+		for _, part := range strings.Split(value, ",") {
+			if encoded := strings.TrimPrefix(strings.TrimSpace(part), "md5="); encoded != strings.TrimSpace(part) {
+				if sum, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(sum) == md5.Size {
+					return sum
+				}
+			}
+		}
+	}
+	// Content-MD5 of a partial response is the digest of the range only
+	if !partial {
+		if sum, err := base64.StdEncoding.DecodeString(h.Get("Content-MD5")); err == nil && len(sum) == md5.Size {
+			return sum
+		}
+	}
+	return nil
+}
+
+// verifier hashes a file as it is copied and compares it with the expected checksum
+type verifier struct {
+	expected *checksum
+	md5      hash.Hash
+	sha256   hash.Hash
+}
+
+func newVerifier(expected *checksum) *verifier {
+	return &verifier{expected: expected, md5: md5.New(), sha256: sha256.New()}
+}
+
+func (v *verifier) Write(p []byte) (int, error) {
+	v.md5.Write(p)
+	return v.sha256.Write(p)
+}
+
+// returns the SHA-256 of the bytes written, hex encoded
+func (v *verifier) SHA256() string {
+	return hex.EncodeToString(v.sha256.Sum(nil))
+}
+
+// returns the MD5 of the bytes written, hex encoded
+func (v *verifier) MD5() string {
+	return hex.EncodeToString(v.md5.Sum(nil))
+}
+
+// compares what was written with the expected checksum
+func (v *verifier) Status() string {
+	if v.expected == nil {
+		return checksumUnverified
+	}
+	got := v.md5.Sum(nil)
+	if v.expected.Algorithm == "sha256" {
+		got = v.sha256.Sum(nil)
+	}
+	if !bytes.Equal(got, v.expected.Sum) {
+		return checksumMismatch
+	}
+	return checksumVerified
+}
+
+/*
+	Function sets the expected checksum headers sent before a streamed body,
+	as "md5=<hex>" or "sha256=<hex>"; the digests computed while streaming
+	follow as X-Checksum-Sha256 and X-Checksum-Md5 trailers
+*/
+func setExpectedChecksum(w http.ResponseWriter, expected *checksum) {
+	if expected == nil {
+		return
+	}
+	w.Header().Set("X-Checksum-Expected", expected.Algorithm+"="+hex.EncodeToString(expected.Sum))
+	w.Header().Set("X-Checksum-Source", expected.Source)
+}
+
+/*
+	holdLastWriter passes everything on but the last write, which is kept
+	until Flush; a streamed file is held back by one buffer until its checksum
+	is verified, so a client never gets the complete body of a bad file
+*/
+type holdLastWriter struct {
+	w    io.Writer
+	held []byte
+}
+
+func (h *holdLastWriter) Write(p []byte) (int, error) {
+	if len(h.held) > 0 {
+		if _, err := h.w.Write(h.held); err != nil {
+			return 0, err
+		}
+	}
+	h.held = append(h.held[:0], p...)
+	return len(p), nil
+}
+
+// writes the held bytes
+func (h *holdLastWriter) Flush() error {
+	_, err := h.w.Write(h.held)
+	h.held = nil
+	return err
+}
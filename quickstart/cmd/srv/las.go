@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+	LogFile is a well log read from a LAS file: the curves and parameters
+	of the log, and for LAS 3.0 the other data sets of the file (tops,
+	core and so on) as tables
+*/
+type LogFile struct {
+	Version    LASVersion `json:"version"`
+	Well       WellHeader `json:"well"`
+	Curves     []Curve    `json:"curves"`
+	Parameters []LASItem  `json:"parameters,omitempty"`
+	Tables     []LogTable `json:"tables,omitempty"`
+	Other      string     `json:"other,omitempty"`
+}
+
+// LASVersion is the ~V section
+type LASVersion struct {
+	Version   string `json:"version"`
+	Wrap      bool   `json:"wrap"`
+	Delimiter string `json:"delimiter,omitempty"` // DLM of LAS 3.0: SPACE, COMMA or TAB
+}
+
+// LogTable is a LAS 3.0 data set besides the log, such as ~Tops_Data
+type LogTable struct {
+	Name       string    `json:"name"`
+	Parameters []LASItem `json:"parameters,omitempty"`
+	Curves     []Curve   `json:"curves"`
+}
+
+/*
+	WellHeader is the ~W section: the index range and null value as
+	numbers, the usual well identification as strings and every item
+	of the section as it was written
+*/
+type WellHeader struct {
+	Start     float64 `json:"start"`
+	Stop      float64 `json:"stop"`
+	Step      float64 `json:"step"` // 0 when the step varies
+	IndexUnit string  `json:"indexUnit,omitempty"`
+	Null      float64 `json:"null"`
+
+	Company  string `json:"company,omitempty"`
+	Well     string `json:"well,omitempty"`
+	Field    string `json:"field,omitempty"`
+	Location string `json:"location,omitempty"`
+	Country  string `json:"country,omitempty"`
+	Service  string `json:"service,omitempty"`
+	Date     string `json:"date,omitempty"`
+	UWI      string `json:"uwi,omitempty"`
+
+	Items []LASItem `json:"items"`
+}
+
+// LASItem is a header line: MNEM.UNIT VALUE : DESCRIPTION {FORMAT} | ASSOCIATION
+type LASItem struct {
+	Mnemonic    string `json:"mnemonic"`
+	Unit        string `json:"unit,omitempty"`
+	Value       string `json:"value,omitempty"`
+	Description string `json:"description,omitempty"`
+	Format      string `json:"format,omitempty"`      // LAS 3.0
+	Association string `json:"association,omitempty"` // LAS 3.0
+}
+
+/*
+	Curve is a curve of the ~C section with its values from the ~A section.
+	The values are in Data, unless the LAS 3.0 format of the curve makes
+	them strings (Text) or the curve is an array (Array, a vector per sample)
+*/
+type Curve struct {
+	Mnemonic    string        `json:"mnemonic"`
+	Unit        string        `json:"unit,omitempty"`
+	APICode     string        `json:"apiCode,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Format      string        `json:"format,omitempty"`
+	Data        curveValues   `json:"data,omitempty"`
+	Text        []string      `json:"text,omitempty"`
+	Array       []curveValues `json:"array,omitempty"`
+}
+
+// curveValues holds the samples of a curve, NaN for null values
+type curveValues []float64
+
+// encodes null values, NaN, as JSON null
+func (v curveValues) MarshalJSON() ([]byte, error) {
+	buf := make([]byte, 0, len(v)*8+2)
+	buf = append(buf, '[')
+	for i, f := range v {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			buf = append(buf, "null"...)
+		} else {
+			buf = strconv.AppendFloat(buf, f, 'g', -1, 64)
+		}
+	}
+	return append(buf, ']'), nil
+}
+
+// LASError is a problem at a line of a LAS file
+type LASError struct {
+	Line    int
+	Message string
+}
+
+func (e *LASError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// lasLine is a line of a section with its number in the file
+type lasLine struct {
+	number int
+	text   string
+}
+
+// lasSection is a section of the file, Name is the line starting with ~
+type lasSection struct {
+	Name  string
+	Line  int
+	Lines []lasLine
+}
+
+/*
+	Function splits a LAS file into its sections, dropping blank lines and
+	comments (lines starting with #); lines before the first section are
+	an error
+*/
+func splitLASSections(r io.Reader) ([]*lasSection, error) {
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	var sections []*lasSection
+	number := 0
+	for scanner.Scan() {
+		number++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if number == 1 {
+			text = strings.TrimPrefix(text, "\ufeff") // byte order mark
+		}
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "~") {
+			sections = append(sections, &lasSection{Name: trimmed, Line: number})
+			continue
+		}
+		if len(sections) == 0 {
+			return nil, &LASError{Line: number, Message: "text before the first ~ section"}
+		}
+		last := sections[len(sections)-1]
+		last.Lines = append(last.Lines, lasLine{number: number, text: text})
+	}
+	return sections, scanner.Err()
+}
+
+// returns the lines of a section as text, for ~O
+func (s *lasSection) text() string {
+	lines := make([]string, len(s.Lines))
+	for i, line := range s.Lines {
+		lines[i] = strings.TrimSpace(line.text)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// returns the upper case letter after ~ that names a LAS 2.0 section
+func (s *lasSection) letter() byte {
+	if len(s.Name) < 2 {
+		return 0
+	}
+	c := s.Name[1]
+	if c >= 'a' && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	return c
+}
+
+/*
+	Function reads a header line "MNEM.UNIT VALUE : DESCRIPTION": the
+	mnemonic ends at the first dot, the unit at the first space after it and
+	the description starts after the last colon, so values may hold colons
+	such as times
+*/
+func parseLASItem(line lasLine) (LASItem, error) {
+
+	text := strings.TrimSpace(line.text)
+	dot := strings.Index(text, ".")
+	if dot < 0 {
+		return LASItem{}, &LASError{Line: line.number, Message: fmt.Sprintf("no dot after the mnemonic in %q", text)}
+	}
+	item := LASItem{Mnemonic: strings.TrimSpace(text[:dot])}
+	if item.Mnemonic == "" {
+		return LASItem{}, &LASError{Line: line.number, Message: fmt.Sprintf("no mnemonic in %q", text)}
+	}
+
+	rest := text[dot+1:]
+	if space := strings.IndexAny(rest, " \t"); space >= 0 {
+		item.Unit, rest = rest[:space], rest[space:]
+	} else if colon := strings.LastIndex(rest, ":"); colon >= 0 {
+		item.Unit, rest = rest[:colon], rest[colon:]
+	} else {
+		item.Unit, rest = rest, ""
+	}
+
+	if colon := strings.LastIndex(rest, ":"); colon >= 0 {
+		item.Value, item.Description = strings.TrimSpace(rest[:colon]), strings.TrimSpace(rest[colon+1:])
+	} else {
+		item.Value = strings.TrimSpace(rest)
+	}
+	return item, nil
+}
+
+// reads all header lines of a section, with the LAS 3.0 format and association once the version is known
+func (l *LogFile) parseItems(s *lasSection) ([]LASItem, error) {
+	parse := parseLASItem
+	if l.Version.isLAS3() {
+		parse = parseLAS3Item
+	}
+	items := make([]LASItem, 0, len(s.Lines))
+	for _, line := range s.Lines {
+		item, err := parse(line)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// finds the item with the mnemonic, without case
+func findLASItem(items []LASItem, mnemonic string) (LASItem, bool) {
+	for _, item := range items {
+		if strings.EqualFold(item.Mnemonic, mnemonic) {
+			return item, true
+		}
+	}
+	return LASItem{}, false
+}
+
+/*
+	Function parses a LAS 2.0 file: the version, well, curve and parameter
+	sections, the other section as text and the data section, wrapped or
+	not, into the values of each curve; samples equal to NULL become NaN.
+	LAS 3.0 files are handed to parseLAS3
+*/
+func parseLAS(r io.Reader) (*LogFile, error) {
+
+	sections, err := splitLASSections(r)
+	if err != nil {
+		return nil, err
+	}
+
+	log := &LogFile{}
+	var version *lasSection
+	for _, s := range sections {
+		if s.letter() == 'V' {
+			version = s
+			break
+		}
+	}
+	if version == nil {
+		return nil, errors.New("no ~V section, not a LAS file")
+	}
+	if err := log.parseVersion(version); err != nil {
+		return nil, err
+	}
+	if log.Version.isLAS3() {
+		if err := log.parseLAS3(sections); err != nil {
+			return nil, err
+		}
+		return log, nil
+	}
+
+	var well, curves, data *lasSection
+	for _, s := range sections {
+		switch s.letter() {
+		case 'W':
+			well = s
+		case 'C':
+			curves = s
+		case 'P':
+			if log.Parameters, err = log.parseItems(s); err != nil {
+				return nil, err
+			}
+		case 'O':
+			log.Other = s.text()
+		case 'A':
+			data = s
+		}
+	}
+	if well == nil || curves == nil || data == nil {
+		return nil, errors.New("a LAS file needs ~W, ~C and ~A sections")
+	}
+	if err := log.parseWell(well); err != nil {
+		return nil, err
+	}
+	if err := log.parseCurves(curves); err != nil {
+		return nil, err
+	}
+	if err := log.parseData(data); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// reads VERS, WRAP and the DLM of LAS 3.0 from the ~V section
+func (l *LogFile) parseVersion(s *lasSection) error {
+
+	items, err := l.parseItems(s)
+	if err != nil {
+		return err
+	}
+	vers, ok := findLASItem(items, "VERS")
+	if !ok {
+		return &LASError{Line: s.Line, Message: "no VERS in ~V section"}
+	}
+	l.Version.Version = vers.Value
+	switch {
+	case strings.HasPrefix(vers.Value, "1.2"), strings.HasPrefix(vers.Value, "2."), l.Version.isLAS3():
+	default:
+		return &LASError{Line: s.Line, Message: fmt.Sprintf("LAS version %q is not supported", vers.Value)}
+	}
+
+	if wrap, ok := findLASItem(items, "WRAP"); ok {
+		switch strings.ToUpper(wrap.Value) {
+		case "YES":
+			l.Version.Wrap = true
+		case "NO", "":
+		default:
+			return &LASError{Line: s.Line, Message: fmt.Sprintf("WRAP must be YES or NO, not %q", wrap.Value)}
+		}
+	}
+	if !l.Version.isLAS3() {
+		return nil
+	}
+
+	if l.Version.Wrap {
+		return &LASError{Line: s.Line, Message: "LAS 3.0 data cannot be wrapped"}
+	}
+	l.Version.Delimiter = "SPACE"
+	if dlm, ok := findLASItem(items, "DLM"); ok && dlm.Value != "" {
+		l.Version.Delimiter = strings.ToUpper(dlm.Value)
+	}
+	if _, ok := lasDelimiters[l.Version.Delimiter]; !ok {
+		return &LASError{Line: s.Line, Message: fmt.Sprintf("DLM must be SPACE, COMMA or TAB, not %q", l.Version.Delimiter)}
+	}
+	return nil
+}
+
+/*
+	Function reads the ~W section. LAS 1.2 files put the value of the items
+	besides STRT, STOP, STEP and NULL where the description goes, after the
+	colon: "COMP.  COMPANY: ANY OIL COMPANY INC."
+*/
+func (l *LogFile) parseWell(s *lasSection) error {
+
+	items, err := l.parseItems(s)
+	if err != nil {
+		return err
+	}
+	w := &l.Well
+	numbers := map[string]*float64{"STRT": &w.Start, "STOP": &w.Stop, "STEP": &w.Step, "NULL": &w.Null}
+	if strings.HasPrefix(l.Version.Version, "1.") {
+		for i := range items {
+			if _, ok := numbers[strings.ToUpper(items[i].Mnemonic)]; !ok && items[i].Description != "" {
+				items[i].Value, items[i].Description = items[i].Description, items[i].Value
+			}
+		}
+	}
+	w.Items = items
+
+	for mnemonic, field := range numbers {
+		item, ok := findLASItem(items, mnemonic)
+		if !ok {
+			return &LASError{Line: s.Line, Message: "no " + mnemonic + " in ~W section"}
+		}
+		if *field, err = strconv.ParseFloat(item.Value, 64); err != nil {
+			return &LASError{Line: s.Line, Message: fmt.Sprintf("%s %q is not a number", mnemonic, item.Value)}
+		}
+	}
+	strt, _ := findLASItem(items, "STRT")
+	w.IndexUnit = strt.Unit
+
+	texts := map[string]*string{"COMP": &w.Company, "WELL": &w.Well, "FLD": &w.Field, "LOC": &w.Location,
+		"CTRY": &w.Country, "SRVC": &w.Service, "DATE": &w.Date, "UWI": &w.UWI}
+	for mnemonic, field := range texts {
+		if item, ok := findLASItem(items, mnemonic); ok {
+			*field = item.Value
+		}
+	}
+	return nil
+}
+
+// reads the curve definitions of the ~C section, the API code is in the value
+func (l *LogFile) parseCurves(s *lasSection) error {
+
+	items, err := l.parseItems(s)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return &LASError{Line: s.Line, Message: "no curves in ~C section"}
+	}
+	l.Curves = make([]Curve, len(items))
+	for i, item := range items {
+		l.Curves[i] = Curve{Mnemonic: item.Mnemonic, Unit: item.Unit, APICode: item.Value, Description: item.Description}
+	}
+	return nil
+}
+
+// reads a sample, NULL values are NaN
+func (l *LogFile) parseSample(field string, line int) (float64, error) {
+	value, err := strconv.ParseFloat(field, 64)
+	if err != nil {
+		return 0, &LASError{Line: line, Message: fmt.Sprintf("%q is not a number", field)}
+	}
+	if l.isNull(value) {
+		return math.NaN(), nil
+	}
+	return value, nil
+}
+
+// reports whether a value is the NULL value of the file, give or take rounding
+func (l *LogFile) isNull(value float64) bool {
+	return value == l.Well.Null || math.Abs(value-l.Well.Null) < 1e-9*math.Max(1, math.Abs(l.Well.Null))
+}
+
+/*
+	Function reads the ~A section into the curves: without wrapping a line
+	holds a sample of every curve; wrapped, a line holds the index alone
+	followed by lines with the other samples
+*/
+func (l *LogFile) parseData(s *lasSection) error {
+
+	n := len(l.Curves)
+	for i := range l.Curves {
+		l.Curves[i].Data = make(curveValues, 0, len(s.Lines))
+	}
+
+	row := make([]float64, 0, n)
+	rowLine := 0
+	for _, line := range s.Lines {
+		fields := strings.Fields(line.text)
+		if !l.Version.Wrap && len(fields) != n {
+			return &LASError{Line: line.number, Message: fmt.Sprintf("has %d values, there are %d curves", len(fields), n)}
+		}
+		if l.Version.Wrap && len(row) == 0 {
+			if len(fields) != 1 {
+				return &LASError{Line: line.number, Message: "a wrapped row must start with the index alone on its line"}
+			}
+			rowLine = line.number
+		}
+
+		for _, field := range fields {
+			value, err := l.parseSample(field, line.number)
+			if err != nil {
+				return err
+			}
+			row = append(row, value)
+		}
+		if len(row) > n {
+			return &LASError{Line: line.number, Message: fmt.Sprintf("the row starting at line %d has more than %d values", rowLine, n)}
+		}
+		if len(row) == n {
+			for i, value := range row {
+				l.Curves[i].Data = append(l.Curves[i].Data, value)
+			}
+			row = row[:0]
+		}
+	}
+	if len(row) > 0 {
+		return &LASError{Line: rowLine, Message: fmt.Sprintf("the last row has %d of %d values", len(row), n)}
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// delimiters of LAS 3.0 data lines, by DLM
+var lasDelimiters = map[string]rune{"SPACE": ' ', "COMMA": ',', "TAB": '\t'}
+
+// sections a LAS 3.0 data set is made of, as name suffixes
+var lasSetSections = []string{"_parameter", "_definition", "_data"}
+
+// an array curve is defined as a curve per element: NMR[1], NMR[2]...
+var arrayMnemonic = regexp.MustCompile(`^(.+)\[(\d+)\]$`)
+
+func (v LASVersion) isLAS3() bool {
+	return strings.HasPrefix(v.Version, "3.")
+}
+
+/*
+	lasDataSet is the parameter, definition and data sections of a LAS 3.0
+	data set, such as ~Tops_Parameter, ~Tops_Definition and ~Tops_Data;
+	association is the data set whose definition the data section names
+*/
+type lasDataSet struct {
+	name                        string
+	parameter, definition, data *lasSection
+	association                 string
+}
+
+// lasColumn is a column of a LAS 3.0 data section: the curve, and the element of an array curve
+type lasColumn struct {
+	curve   int
+	element int // -1 for plain curves
+	text    bool
+}
+
+/*
+	Function splits a LAS 3.0 section name into its data set and the kind
+	of section: "~Tops_Data[2] | Tops_Definition[2]" is the data of Tops[2]
+	associated with "Tops_Definition[2]". The LAS 2.0 ~P, ~C and ~A sections
+	are the Log data set, ~V, ~W and ~O their own kind
+*/
+func lasSectionKind(name string) (set, kind, association string) {
+
+	name = strings.TrimPrefix(strings.TrimSpace(name), "~")
+	if bar := strings.Index(name, "|"); bar >= 0 {
+		name, association = name[:bar], strings.TrimSpace(name[bar+1:])
+	}
+	fields := strings.Fields(name)
+	if len(fields) == 0 {
+		return "", "", association
+	}
+	name = fields[0]
+
+	base, index := name, ""
+	if bracket := strings.LastIndex(name, "["); bracket > 0 && strings.HasSuffix(name, "]") {
+		base, index = name[:bracket], name[bracket:]
+	}
+	for _, suffix := range lasSetSections {
+		if lower := strings.ToLower(base); strings.HasSuffix(lower, suffix) && len(lower) > len(suffix) {
+			return base[:len(base)-len(suffix)] + index, suffix[1:], association
+		}
+	}
+
+	switch unicode.ToUpper(rune(name[0])) {
+	case 'V':
+		return "", "version", association
+	case 'W':
+		return "", "well", association
+	case 'O':
+		return "", "other", association
+	case 'P':
+		return "Log", "parameter", association
+	case 'C':
+		return "Log", "definition", association
+	case 'A':
+		return "Log", "data", association
+	}
+	return "", "", association
+}
+
+/*
+	Function reads a LAS 3.0 header line, which may end with a format in
+	braces and an association after a bar:
+
+	DATE .  13/12/1986 : Log date {DD/MM/YYYY} | Run[1]
+*/
+func parseLAS3Item(line lasLine) (LASItem, error) {
+
+	text := strings.TrimSpace(line.text)
+	association := ""
+	if bar := strings.LastIndex(text, "|"); bar >= 0 {
+		text, association = strings.TrimSpace(text[:bar]), strings.TrimSpace(text[bar+1:])
+	}
+	format := ""
+	if brace := strings.LastIndex(text, "{"); brace >= 0 && strings.HasSuffix(text, "}") {
+		text, format = strings.TrimSpace(text[:brace]), text[brace+1:len(text)-1]
+	}
+
+	item, err := parseLASItem(lasLine{number: line.number, text: text})
+	item.Format, item.Association = format, association
+	return item, err
+}
+
+// formats of LAS 3.0 values that are not numbers: strings, dates and times
+func isTextFormat(format string) bool {
+	if format == "" {
+		return false
+	}
+	switch unicode.ToUpper(rune(format[0])) {
+	case 'F', 'E', 'I':
+		return false
+	}
+	return true
+}
+
+/*
+	Function parses the data sets of a LAS 3.0 file: the Log data set gives
+	the curves and parameters of the file, every other one (tops, core and so
+	on) a table. A data section reads the definition it is associated with,
+	or the one of its own data set
+*/
+func (l *LogFile) parseLAS3(sections []*lasSection) error {
+
+	var well *lasSection
+	var sets []*lasDataSet
+	byName := make(map[string]*lasDataSet)
+	for _, s := range sections {
+		name, kind, association := lasSectionKind(s.Name)
+		switch kind {
+		case "well":
+			well = s
+			continue
+		case "other":
+			l.Other = s.text()
+			continue
+		case "parameter", "definition", "data":
+		default:
+			continue
+		}
+
+		set := byName[strings.ToLower(name)]
+		if set == nil {
+			set = &lasDataSet{name: name}
+			byName[strings.ToLower(name)] = set
+			sets = append(sets, set)
+		}
+		switch kind {
+		case "parameter":
+			set.parameter = s
+		case "definition":
+			set.definition = s
+		case "data":
+			set.data = s
+			if association != "" {
+				set.association, _, _ = lasSectionKind(association)
+			}
+		}
+	}
+
+	if well == nil {
+		return errors.New("a LAS file needs a ~W section")
+	}
+	if err := l.parseWell(well); err != nil {
+		return err
+	}
+
+	hasLog, hasData := false, false
+	for _, set := range sets {
+		table := LogTable{Name: set.name, Curves: []Curve{}}
+		var err error
+		if set.parameter != nil {
+			if table.Parameters, err = l.parseItems(set.parameter); err != nil {
+				return err
+			}
+		}
+
+		definition := set.definition
+		if set.data != nil && set.association != "" {
+			associated := byName[strings.ToLower(set.association)]
+			if associated == nil || associated.definition == nil {
+				return &LASError{Line: set.data.Line, Message: fmt.Sprintf("%s names %s, which has no definition section", set.data.Name, set.association)}
+			}
+			definition = associated.definition
+		}
+		if set.data != nil && definition == nil {
+			return &LASError{Line: set.data.Line, Message: fmt.Sprintf("no ~%s_Definition section for %s", set.name, set.data.Name)}
+		}
+		if definition != nil {
+			if table.Curves, err = l.parseDataSet(definition, set.data); err != nil {
+				return err
+			}
+		}
+		hasData = hasData || set.data != nil
+
+		if !hasLog && strings.EqualFold(set.name, "Log") {
+			l.Curves, l.Parameters, hasLog = table.Curves, table.Parameters, true
+		} else {
+			l.Tables = append(l.Tables, table)
+		}
+	}
+	if !hasData {
+		return errors.New("no data sections in the LAS file")
+	}
+	if l.Curves == nil {
+		l.Curves = []Curve{}
+	}
+	return nil
+}
+
+/*
+	Function reads the curves of a definition section and the values of its
+	data section, if any, a line per row with the delimiter of the file.
+	Curves whose format is not a number are read as text, and the elements
+	of an array curve become a vector per row
+*/
+func (l *LogFile) parseDataSet(definition, data *lasSection) ([]Curve, error) {
+
+	items, err := l.parseItems(definition)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, &LASError{Line: definition.Line, Message: "no curves in " + definition.Name}
+	}
+
+	var curves []Curve
+	var columns []lasColumn
+	var elements []int // of each curve, 0 unless it is an array
+	for _, item := range items {
+		m := arrayMnemonic.FindStringSubmatch(item.Mnemonic)
+		last := len(curves) - 1
+		if m != nil && last >= 0 && elements[last] > 0 && curves[last].Mnemonic == m[1] {
+			columns = append(columns, lasColumn{curve: last, element: elements[last]})
+			elements[last]++
+			continue
+		}
+
+		curve := Curve{Mnemonic: item.Mnemonic, Unit: item.Unit, APICode: item.Value, Description: item.Description, Format: item.Format}
+		column := lasColumn{curve: len(curves), element: -1, text: isTextFormat(item.Format)}
+		size := 0
+		if m != nil {
+			curve.Mnemonic, column.element, column.text, size = m[1], 0, false, 1
+		}
+		curves, elements = append(curves, curve), append(elements, size)
+		columns = append(columns, column)
+	}
+	if data == nil {
+		return curves, nil
+	}
+
+	delimiter := lasDelimiters[l.Version.Delimiter]
+	for _, line := range data.Lines {
+		fields := splitLASFields(line.text, delimiter)
+		if len(fields) != len(columns) {
+			return nil, &LASError{Line: line.number, Message: fmt.Sprintf("has %d values, %s defines %d", len(fields), definition.Name, len(columns))}
+		}
+		for i := range curves {
+			if elements[i] > 0 {
+				curves[i].Array = append(curves[i].Array, make(curveValues, elements[i]))
+			}
+		}
+
+		for i, field := range fields {
+			column := columns[i]
+			curve := &curves[column.curve]
+			if column.text {
+				if value, err := strconv.ParseFloat(field, 64); err == nil && l.isNull(value) {
+					field = ""
+				}
+				curve.Text = append(curve.Text, field)
+				continue
+			}
+
+			value := math.NaN()
+			if field != "" {
+				if value, err = l.parseSample(field, line.number); err != nil {
+					return nil, err
+				}
+			}
+			if column.element >= 0 {
+				curve.Array[len(curve.Array)-1][column.element] = value
+			} else {
+				curve.Data = append(curve.Data, value)
+			}
+		}
+	}
+	return curves, nil
+}
+
+/*
+	Function splits a data line at the delimiter, SPACE meaning any run of
+	blanks; values in double quotes may hold the delimiter
+*/
+func splitLASFields(text string, delimiter rune) []string {
+
+	var fields []string
+	var field strings.Builder
+	quoted, started := false, false
+	for _, r := range text {
+		switch {
+		case r == '"':
+			quoted, started = !quoted, true
+		case !quoted && delimiter == ' ' && (r == ' ' || r == '\t'):
+			if started {
+				fields = append(fields, field.String())
+				field.Reset()
+				started = false
+			}
+		case !quoted && r == delimiter:
+			fields = append(fields, strings.TrimSpace(field.String()))
+			field.Reset()
+		default:
+			field.WriteRune(r)
+			started = true
+		}
+	}
+	if delimiter != ' ' || started {
+		fields = append(fields, strings.TrimSpace(field.String()))
+	}
+	return fields
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// LAS 3.0 file with a Log data set holding an array curve, and tops and core data sets
+const las30 = `~Version
+VERS.   3.0 : CWLS LOG ASCII STANDARD - VERSION 3.0
+WRAP.   NO  : ONE LINE PER DEPTH STEP
+DLM .   COMMA : DELIMITING CHARACTER BETWEEN DATA COLUMNS
+~Well
+STRT .M    1670.0000 : First Index Value {F}
+STOP .M    1669.7500 : Last Index Value {F}
+STEP .M    -0.1250   : STEP
+NULL .     -999.25   : NULL VALUE
+WELL .     AAAAA_2   : WELL
+DATE .     13/12/1986 : LOG DATE {DD/MM/YYYY}
+~Log_Parameter
+RUN_DEPTH.M  0, 1500 : Run depth interval {F} | Run[1]
+BHT .DEGC 35.5 : Bottom hole temperature {F}
+~Log_Definition
+DEPT .M             : DEPTH {F}
+DT   .US/M  60 520 32 00 : SONIC TRANSIT TIME {F}
+NMR[1] .ms  : NMR echo array {AF}
+NMR[2] .ms  : NMR echo array {AF}
+NMR[3] .ms  : NMR echo array {AF}
+~Log_Data | Log_Definition
+1670.000, 123.450, 10, 20, 30
+1669.875, 123.450, 11, -999.25, 31
+1669.750, , 12, 22, 32
+~Tops_Definition
+TOPT.M   : Top Depth {F}
+TOPB.M   : Bottom Depth {F}
+TOPN.    : Top Name {S}
+~Tops_Data | Tops_Definition
+545.50, 570.50, "Viking, upper"
+570.50, -999.25, Colorado
+600.00, 650.00, -999.2500
+~Core_Parameter
+C_SRS . CONV : Core type {S}
+~Core_Definition
+CORET.M : Core top {F}
+PERM .md : Permeability {F}
+~Core_Data[1] | Core_Definition
+540.0, 45.1
+541.0, 12.3
+~Core_Data[2] | Core_Definition
+612.0, 3.5
+~Other
+Some notes
+`
+
+func TestParseLAS3(t *testing.T) {
+
+	log, err := parseLAS(strings.NewReader(las30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.Version.Delimiter != "COMMA" || log.Well.Well != "AAAAA_2" || log.Well.Date != "13/12/1986" || log.Other != "Some notes" {
+		t.Errorf("version %+v, well %+v, other %q", log.Version, log.Well, log.Other)
+	}
+
+	// the Log data set gives the curves and parameters of the file
+	run, ok := findLASItem(log.Parameters, "RUN_DEPTH")
+	if !ok || run.Value != "0, 1500" || run.Format != "F" || run.Association != "Run[1]" {
+		t.Errorf("RUN_DEPTH parameter %+v", run)
+	}
+	if len(log.Curves) != 3 {
+		t.Fatalf("%d log curves, want DEPT, DT and NMR", len(log.Curves))
+	}
+	dt, nmr := log.Curves[1], log.Curves[2]
+	if dt.APICode != "60 520 32 00" || curveJSON(t, dt.Data) != "[123.45,123.45,null]" {
+		t.Errorf("DT %+v", dt)
+	}
+	// the elements of an array curve come together, a vector per row
+	if nmr.Mnemonic != "NMR" || nmr.Unit != "ms" || nmr.Data != nil || len(nmr.Array) != 3 {
+		t.Fatalf("NMR %+v", nmr)
+	}
+	for i, want := range []string{"[10,20,30]", "[11,null,31]", "[12,22,32]"} {
+		if got := curveJSON(t, nmr.Array[i]); got != want {
+			t.Errorf("NMR row %d is %s, want %s", i, got, want)
+		}
+	}
+
+	tables := map[string]LogTable{}
+	for _, table := range log.Tables {
+		tables[table.Name] = table
+	}
+	if len(tables) != 4 {
+		t.Fatalf("tables %+v, want Tops, Core, Core[1] and Core[2]", log.Tables)
+	}
+
+	// text curves keep delimiters in quotes, the NULL value is an empty string
+	tops := tables["Tops"]
+	if got := curveJSON(t, tops.Curves[1].Data); got != "[570.5,null,650]" {
+		t.Errorf("TOPB %s", got)
+	}
+	if got := strings.Join(tops.Curves[2].Text, "|"); got != "Viking, upper|Colorado|" {
+		t.Errorf("TOPN %q", tops.Curves[2].Text)
+	}
+
+	// data sections read the definition they are associated with
+	if core := tables["Core"]; len(core.Parameters) != 1 || len(core.Curves) != 2 || core.Curves[0].Data != nil {
+		t.Errorf("Core %+v", core)
+	}
+	for name, want := range map[string][]string{"Core[1]": {"[540,541]", "[45.1,12.3]"}, "Core[2]": {"[612]", "[3.5]"}} {
+		table := tables[name]
+		if len(table.Curves) != 2 || table.Curves[1].Mnemonic != "PERM" || table.Curves[1].Unit != "md" {
+			t.Errorf("%s curves %+v", name, table.Curves)
+			continue
+		}
+		for i, c := range table.Curves {
+			if got := curveJSON(t, c.Data); got != want[i] {
+				t.Errorf("%s %s is %s, want %s", name, c.Mnemonic, got, want[i])
+			}
+		}
+	}
+}
+
+func TestParseLAS3Errors(t *testing.T) {
+
+	for _, c := range []struct {
+		name, old, new, err string
+	}{
+		{"wrapped", "WRAP.   NO", "WRAP.   YES", "line 1: LAS 3.0 data cannot be wrapped"},
+		{"unknown delimiter", "COMMA :", "PIPE :", `line 1: DLM must be SPACE, COMMA or TAB, not "PIPE"`},
+		{"association without definition", "~Core_Data[2] | Core_Definition", "~Core_Data[2] | Cores_Definition",
+			"line 41: ~Core_Data[2] | Cores_Definition names Cores, which has no definition section"},
+		{"data without definition", "~Tops_Data | Tops_Definition", "~Picks_Data", "line 29: no ~Picks_Definition section for ~Picks_Data"},
+		{"short row", "541.0, 12.3", "541.0", "line 40: has 1 values, ~Core_Definition defines 2"},
+		{"array element not a number", "11, -999.25, 31", "11, x, 31", `line 23: "x" is not a number`},
+	} {
+		if !strings.Contains(las30, c.old) {
+			t.Fatalf("%s: %q is not in the file", c.name, c.old)
+		}
+		_, err := parseLAS(strings.NewReader(strings.Replace(las30, c.old, c.new, 1)))
+		if err == nil || err.Error() != c.err {
+			t.Errorf("%s: error %v, want %q", c.name, err, c.err)
+		}
+	}
+}
+
+func TestLASSectionKind(t *testing.T) {
+	for _, c := range []struct{ section, set, kind, association string }{
+		{"~Tops_Data | Tops_Definition", "Tops", "data", "Tops_Definition"},
+		{"~Core_Data[2] | Core_Definition[1]", "Core[2]", "data", "Core_Definition[1]"},
+		{"~DRILLING_PARAMETER", "DRILLING", "parameter", ""},
+		{"~Curve Information", "Log", "definition", ""},
+		{"~ASCII", "Log", "data", ""},
+		{"~Well Information", "", "well", ""},
+		{"~Data", "", "", ""},
+	} {
+		set, kind, association := lasSectionKind(c.section)
+		if set != c.set || kind != c.kind || association != c.association {
+			t.Errorf("%q is %q %q %q, want %q %q %q", c.section, set, kind, association, c.set, c.kind, c.association)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// LAS 2.0 example of the CWLS specification, shortened
+const las20 = `~VERSION INFORMATION
+ VERS.                  2.0 :   CWLS LOG ASCII STANDARD -VERSION 2.0
+ WRAP.                  NO  :   ONE LINE PER DEPTH STEP
+~WELL INFORMATION
+#MNEM.UNIT              DATA                       DESCRIPTION
+ STRT    .M              1670.0000                :START DEPTH
+ STOP    .M              1669.7500                :STOP DEPTH
+ STEP    .M              -0.1250                  :STEP
+ NULL    .               -999.25                  :NULL VALUE
+ COMP    .       ANY OIL COMPANY INC.             :COMPANY
+ WELL    .       AAAAA_2                          :WELL
+ TIME    .       13:45:00                         :TIME
+~CURVE INFORMATION
+ DEPT    .M                  :  1  DEPTH
+ DT      .US/M  60 520 32 00 :  2  SONIC TRANSIT TIME
+ RHOB    .K/M3  45 350 01 00 :  3  BULK DENSITY
+ GR      .GAPI               :  4  GAMMA RAY
+~PARAMETER INFORMATION
+ MUD     .       GEL CHEM        :   MUD TYPE
+ BHT     .DEGC   35.5000         :   BOTTOM HOLE TEMPERATURE
+~Other
+     Note: The logging tools became stuck at 625 metres
+~A  DEPTH     DT    RHOB     GR
+1670.000   123.450 2550.000  -999.25
+1669.875   123.450 2550.000  12.5
+1669.750   -999.2500000001 2550.000  13
+`
+
+// wrapped LAS 2.0: the index alone on a line, then the other values over as many lines as needed
+const las20wrap = `~VERSION INFORMATION
+ VERS.                  2.0 :   CWLS LOG ASCII STANDARD -VERSION 2.0
+ WRAP.                  YES :   MULTIPLE LINES PER DEPTH STEP
+~WELL INFORMATION
+ STRT    .M              910.000000               :START DEPTH
+ STOP    .M              909.875000               :STOP DEPTH
+ STEP    .M              -0.1250                  :STEP
+ NULL    .               -999.25                  :NULL VALUE
+~CURVE INFORMATION
+ DEPT    .M                  :  DEPTH
+ DT      .US/M               :  SONIC TRANSIT TIME
+ RHOB    .K/M3               :  BULK DENSITY
+ NPHI    .V/V                :  NEUTRON POROSITY
+ GR      .GAPI               :  GAMMA RAY
+~A
+ 910.000000
+ -999.2500  2692.7075  0.3140  19.4086
+ 909.875000
+ -999.2500  2712.6460
+ 0.2886  23.3987
+`
+
+// LAS 1.2 example of the CWLS specification, whose ~W items have the value after the colon
+const las12 = `~VERSION INFORMATION
+ VERS.                 1.2:   CWLS LOG ASCII STANDARD -VERSION 1.2
+ WRAP.                  NO:   ONE LINE PER DEPTH STEP
+~WELL INFORMATION BLOCK
+#MNEM.UNIT       DATA TYPE    INFORMATION
+#---------    -------------   ------------------------------
+ STRT.M        1670.000000:
+ STOP.M        1669.875000:
+ STEP.M            -0.1250:
+ NULL.           -999.2500:
+ COMP.             COMPANY:   ANY OIL COMPANY LTD.
+ WELL.                WELL:   ANY ET AL OIL WELL #12
+ FLD .               FIELD:   EDAM
+ LOC .            LOCATION:   A9-16-49-20W3M
+ SRVC.     SERVICE COMPANY:   ANY LOGGING COMPANY LTD.
+ DATE.            LOG DATE:   25-DEC-1988
+ UWI .      UNIQUE WELL ID:   100091604920W300
+~CURVE INFORMATION
+ DEPT.M                      :  1  DEPTH
+ RHOB.K/M3    45 350 01 00   :  2  BULK DENSITY
+~A  DEPTH     RHOB
+ 1670.000   2550.000
+ 1669.875   -999.2500
+`
+
+// encodes the values of a curve the way /logs does, nulls included
+func curveJSON(t *testing.T, values curveValues) string {
+	t.Helper()
+	b, err := json.Marshal(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestParseLAS(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		las     string
+		wrap    bool
+		well    WellHeader      // without items
+		curves  []string        // mnemonic and unit
+		data    []string        // of each curve, as JSON
+		items   map[string]bool // ~W items expected, by mnemonic:value:description
+		params  int
+		hasNote bool
+	}{
+		{
+			name: "unwrapped LAS 2.0",
+			las:  las20,
+			well: WellHeader{Start: 1670, Stop: 1669.75, Step: -0.125, IndexUnit: "M", Null: -999.25,
+				Company: "ANY OIL COMPANY INC.", Well: "AAAAA_2"},
+			curves: []string{"DEPT M", "DT US/M", "RHOB K/M3", "GR GAPI"},
+			// the null value and values within rounding of it are null
+			data:    []string{"[1670,1669.875,1669.75]", "[123.45,123.45,null]", "[2550,2550,2550]", "[null,12.5,13]"},
+			items:   map[string]bool{"TIME::13:45:00:TIME": true},
+			params:  2,
+			hasNote: true,
+		},
+		{
+			name:   "wrapped LAS 2.0",
+			las:    las20wrap,
+			wrap:   true,
+			well:   WellHeader{Start: 910, Stop: 909.875, Step: -0.125, IndexUnit: "M", Null: -999.25},
+			curves: []string{"DEPT M", "DT US/M", "RHOB K/M3", "NPHI V/V", "GR GAPI"},
+			data:   []string{"[910,909.875]", "[null,null]", "[2692.7075,2712.646]", "[0.314,0.2886]", "[19.4086,23.3987]"},
+		},
+		{
+			name: "LAS 1.2",
+			las:  las12,
+			well: WellHeader{Start: 1670, Stop: 1669.875, Step: -0.125, IndexUnit: "M", Null: -999.25,
+				Company: "ANY OIL COMPANY LTD.", Well: "ANY ET AL OIL WELL #12", Field: "EDAM", Location: "A9-16-49-20W3M",
+				Service: "ANY LOGGING COMPANY LTD.", Date: "25-DEC-1988", UWI: "100091604920W300"},
+			curves: []string{"DEPT M", "RHOB K/M3"},
+			data:   []string{"[1670,1669.875]", "[2550,null]"},
+			// the description keeps what 1.2 puts before the colon
+			items: map[string]bool{"COMP::ANY OIL COMPANY LTD.:COMPANY": true, "STRT:M:1670.000000:": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, err := parseLAS(strings.NewReader(tt.las))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if log.Version.Wrap != tt.wrap {
+				t.Errorf("wrap %v, want %v", log.Version.Wrap, tt.wrap)
+			}
+
+			well := log.Well
+			well.Items = nil
+			if got, _ := json.Marshal(well); string(got) != mustJSON(t, tt.well) {
+				t.Errorf("well\n got %s\nwant %s", got, mustJSON(t, tt.well))
+			}
+			for _, item := range log.Well.Items {
+				delete(tt.items, item.Mnemonic+":"+item.Unit+":"+item.Value+":"+item.Description)
+			}
+			for missing := range tt.items {
+				t.Errorf("no ~W item %s in %+v", missing, log.Well.Items)
+			}
+
+			if len(log.Curves) != len(tt.curves) {
+				t.Fatalf("%d curves, want %d", len(log.Curves), len(tt.curves))
+			}
+			for i, c := range log.Curves {
+				if got := c.Mnemonic + " " + c.Unit; got != tt.curves[i] {
+					t.Errorf("curve %d is %s, want %s", i, got, tt.curves[i])
+				}
+				if got := curveJSON(t, c.Data); got != tt.data[i] {
+					t.Errorf("%s data %s, want %s", c.Mnemonic, got, tt.data[i])
+				}
+			}
+			if len(log.Parameters) != tt.params || (log.Other != "") != tt.hasNote {
+				t.Errorf("%d parameters and other %q", len(log.Parameters), log.Other)
+			}
+		})
+	}
+}
+
+func TestParseLASCurveDetails(t *testing.T) {
+	log, err := parseLAS(strings.NewReader(las20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dt := log.Curves[1]
+	if dt.APICode != "60 520 32 00" || dt.Description != "2  SONIC TRANSIT TIME" {
+		t.Errorf("DT api code %q, description %q", dt.APICode, dt.Description)
+	}
+	if bht, ok := findLASItem(log.Parameters, "bht"); !ok || bht.Unit != "DEGC" || bht.Value != "35.5000" {
+		t.Errorf("BHT parameter %+v", bht)
+	}
+}
+
+func TestParseLASErrors(t *testing.T) {
+
+	replace := func(las, old, new string) string {
+		if !strings.Contains(las, old) {
+			t.Fatalf("%q is not in the file", old)
+		}
+		return strings.Replace(las, old, new, 1)
+	}
+
+	for _, c := range []struct {
+		name, las, err string
+	}{
+		{"not a LAS file", "~Curve\n DEPT.M : DEPTH\n~A\n1\n", "no ~V section, not a LAS file"},
+		{"unsupported version", replace(las20, "2.0 :", "4.0 :"), `line 1: LAS version "4.0" is not supported`},
+		{"bad wrap", replace(las20, "NO  :", "MAYBE :"), `line 1: WRAP must be YES or NO, not "MAYBE"`},
+		{"no null", replace(las20, " NULL    .               -999.25                  :NULL VALUE\n", ""), "line 4: no NULL in ~W section"},
+		{"no data", las20[:strings.Index(las20, "~A")], "a LAS file needs ~W, ~C and ~A sections"},
+		{"short row", replace(las20, "1669.875   123.450 2550.000  12.5", "1669.875   123.450 2550.000"), "line 25: has 3 values, there are 4 curves"},
+		{"not a number", replace(las20, "12.5", "12,5"), `line 25: "12,5" is not a number`},
+		{"wrapped row without the index alone", replace(las20wrap, " 909.875000\n", " 909.875000 -999.25\n"),
+			"line 18: a wrapped row must start with the index alone on its line"},
+		{"wrapped row too long", replace(las20wrap, " 0.2886  23.3987", " 0.2886  23.3987  1"),
+			"line 20: the row starting at line 18 has more than 5 values"},
+		{"wrapped last row short", replace(las20wrap, " 0.2886  23.3987\n", ""), "line 18: the last row has 3 of 5 values"},
+	} {
+		_, err := parseLAS(strings.NewReader(c.las))
+		if err == nil || err.Error() != c.err {
+			t.Errorf("%s: error %v, want %q", c.name, err, c.err)
+		}
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
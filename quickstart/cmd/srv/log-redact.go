@@ -0,0 +1,8 @@
+package main
+
+import "github.com/dmitry-epam/osdu-tutorials-go/quickstart/internal/redact"
+
+// redact.String with the configured client secret
+func redactSecrets(s string) string {
+	return redact.String(s, clientSecret)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testSAS = "sv=2019-02-02&sr=b&sig=Zm9vYmFyc2VjcmV0c2lnbmF0dXJl%3D&se=2030-01-01T00%3A00%3A00Z&sp=r"
+
+// the patterns are tested in internal/redact, the server adds its own client secret
+func TestRedactSecrets(t *testing.T) {
+
+	clientSecret = "s3cr3t-client-value"
+	defer func() { clientSecret = "" }()
+
+	got := redactSecrets("oauth2: cannot fetch token: invalid client s3cr3t-client-value")
+	if got != "oauth2: cannot fetch token: invalid client REDACTED" {
+		t.Errorf("client secret redacted to %q", got)
+	}
+}
+
+func TestWriteFetchErrorRedacts(t *testing.T) {
+
+	rec := httptest.NewRecorder()
+	writeFetchError(rec, 502, "Delivery request failed: Get https://x/k?"+testSAS,
+		fetchError{SRN: "srn:file/csv:1:1", Status: 502, Reason: "refresh_token=8xLOxBtZp8"})
+
+	body := rec.Body.String()
+	for _, secret := range []string{"Zm9vYmFyc2VjcmV0c2lnbmF0dXJl", "8xLOxBtZp8"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("error body %s leaks %q", body, secret)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// resampling methods of /logs
+const (
+	resampleNearest = "nearest"
+	resampleLinear  = "linear"
+	resampleMinMax  = "minmax"
+)
+
+// resampled logs longer than this are refused, the step is too small for the window
+const maxResampledSamples = 1000000
+
+/*
+	LogWindow is the depth window and resampling asked of /logs: Top and
+	Base bound the index, Step and Method resample the curves on a regular
+	grid, all in DepthUnit (the unit of the file when empty)
+*/
+type LogWindow struct {
+	Top       *float64 `json:"top,omitempty"`
+	Base      *float64 `json:"base,omitempty"`
+	Step      float64  `json:"step,omitempty"`
+	Method    string   `json:"method,omitempty"`
+	DepthUnit string   `json:"depthUnit,omitempty"`
+	Samples   int      `json:"samples"` // rows of the log after slicing and resampling
+}
+
+// reads ?top=, ?base=, ?step=, ?method= and ?depth_unit=, nil when none is given
+func parseLogWindow(query url.Values) (*LogWindow, error) {
+
+	window := &LogWindow{Method: query.Get("method"), DepthUnit: query.Get("depth_unit")}
+	given := window.Method != "" || window.DepthUnit != ""
+	for param, value := range map[string]**float64{"top": &window.Top, "base": &window.Base} {
+		if raw := query.Get(param); raw != "" {
+			v, err := strconv.ParseFloat(raw, 64)
+			if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil, errors.New(param + " must be a number")
+			}
+			*value, given = &v, true
+		}
+	}
+	if raw := query.Get("step"); raw != "" {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || !(v > 0) || math.IsInf(v, 0) {
+			return nil, errors.New("step must be a number above 0")
+		}
+		window.Step, given = v, true
+	}
+	if !given {
+		return nil, nil
+	}
+
+	if window.Top != nil && window.Base != nil && *window.Top > *window.Base {
+		return nil, errors.New("top must not be below base")
+	}
+	if window.DepthUnit != "" {
+		unit, ok := lengthUnits[normalizeName(window.DepthUnit)]
+		if !ok {
+			return nil, fmt.Errorf("depth_unit %q is not m, ft or usft", window.DepthUnit)
+		}
+		window.DepthUnit = unit
+	}
+	switch window.Method {
+	case "":
+		if window.Step > 0 {
+			window.Method = resampleLinear
+		}
+	case resampleNearest, resampleLinear, resampleMinMax:
+		if window.Step == 0 {
+			return nil, errors.New("method " + window.Method + " needs a step")
+		}
+	default:
+		return nil, fmt.Errorf("method must be %s, %s or %s", resampleNearest, resampleLinear, resampleMinMax)
+	}
+	return window, nil
+}
+
+/*
+	Function converts the depths of the file to the unit of the window,
+	then keeps the rows between top and base and
+	resamples the log curves on a grid of the step from top (or the first
+	sample): nearest takes the closest sample, linear interpolates between
+	the two around a depth, and minmax keeps two rows per step, the lowest
+	and highest value of each curve within half a step, in depth order, so
+	spikes survive the decimation. Text curves take the nearest sample with
+	every method; the rows keep the direction of the file
+*/
+func (l *LogFile) applyWindow(window *LogWindow) error {
+
+	if len(l.Curves) == 0 {
+		return errors.New("the file has no log curves to slice")
+	}
+	if l.Curves[0].Text != nil || l.Curves[0].Array != nil {
+		return fmt.Errorf("the index %s of the log is not a number", l.Curves[0].Mnemonic)
+	}
+	if window.DepthUnit != "" {
+		if err := l.convertDepths(window.DepthUnit); err != nil {
+			return err
+		}
+	}
+
+	top, base := math.Inf(-1), math.Inf(1)
+	if window.Top != nil {
+		top = *window.Top
+	}
+	if window.Base != nil {
+		base = *window.Base
+	}
+	rows := sortedRows(l.Curves[0].Data, top, base)
+	index := l.Curves[0].Data
+	descending := len(index) > 1 && index[0] > index[len(index)-1]
+
+	for i := range l.Tables {
+		if table := &l.Tables[i]; len(table.Curves) > 0 && table.Curves[0].Data != nil {
+			tableRows := sortedRows(table.Curves[0].Data, top, base)
+			if descending {
+				reverseRows(tableRows)
+			}
+			for j := range table.Curves {
+				table.Curves[j] = table.Curves[j].pick(tableRows)
+			}
+		}
+	}
+
+	if window.Step == 0 || len(rows) == 0 {
+		if descending {
+			reverseRows(rows)
+		}
+		for i := range l.Curves {
+			l.Curves[i] = l.Curves[i].pick(rows)
+		}
+		window.Samples = len(rows)
+		return nil
+	}
+
+	// the grid runs from top to base, or over the samples where they are not given
+	depths := make([]float64, len(rows))
+	for i, row := range rows {
+		depths[i] = index[row]
+	}
+	if window.Top == nil {
+		top = depths[0]
+	}
+	if window.Base == nil {
+		base = depths[len(depths)-1]
+	}
+	n := math.Floor((base-top)/window.Step+1e-9) + 1
+	if n > maxResampledSamples {
+		return fmt.Errorf("a step of %g gives %.0f samples, more than %d", window.Step, n, maxResampledSamples)
+	}
+	grid := make(curveValues, int(n))
+	for i := range grid {
+		grid[i] = top + float64(i)*window.Step
+	}
+
+	var resample func(c Curve) Curve
+	switch window.Method {
+	case resampleNearest:
+		nearest := nearestRows(depths, rows, grid, window.Step)
+		resample = func(c Curve) Curve { return c.pick(nearest) }
+	case resampleLinear:
+		nearest := nearestRows(depths, rows, grid, window.Step)
+		lower, upper, weights := bracketRows(depths, rows, grid)
+		resample = func(c Curve) Curve { return c.interpolate(lower, upper, weights, nearest) }
+	case resampleMinMax:
+		bins := binRows(depths, rows, grid, window.Step)
+		resample = func(c Curve) Curve { return c.extremes(bins) }
+		doubled := make(curveValues, 0, 2*len(grid))
+		for _, depth := range grid {
+			doubled = append(doubled, depth, depth)
+		}
+		grid = doubled
+	}
+
+	for i := range l.Curves[1:] {
+		l.Curves[i+1] = resample(l.Curves[i+1])
+	}
+	l.Curves[0].Data = grid
+	if descending {
+		all := make([]int, len(grid))
+		for i := range all {
+			all[i] = len(grid) - 1 - i
+		}
+		for i := range l.Curves {
+			l.Curves[i] = l.Curves[i].pick(all)
+		}
+	}
+	window.Samples = len(grid)
+	return nil
+}
+
+/*
+	Function converts the depths of the file to a length unit: the index
+	range of the well header and the curves of the log and of the tables in
+	the unit of the index, such as the top and bottom of formations; other
+	curves are left alone even when their unit reads like a length, e.g.
+	TEMP.F in Fahrenheit
+*/
+func (l *LogFile) convertDepths(unit string) error {
+
+	if _, ok := lengthScale(l.Curves[0].Unit, unit); !ok {
+		return fmt.Errorf("the index %s of the log is in %q, not a depth unit", l.Curves[0].Mnemonic, l.Curves[0].Unit)
+	}
+	if scale, ok := lengthScale(l.Well.IndexUnit, unit); ok {
+		l.Well.Start, l.Well.Stop, l.Well.Step = l.Well.Start*scale, l.Well.Stop*scale, l.Well.Step*scale
+		l.Well.IndexUnit = unit
+	}
+	index := l.Curves[0].Unit
+	for i := range l.Curves {
+		l.Curves[i].convertLength(index, unit)
+	}
+	for i := range l.Tables {
+		for j := range l.Tables[i].Curves {
+			l.Tables[i].Curves[j].convertLength(index, unit)
+		}
+	}
+	return nil
+}
+
+// returns the factor from one length unit to another, false when the first is not a length
+func lengthScale(from, to string) (float64, bool) {
+	unit, ok := lengthUnits[normalizeName(from)]
+	if !ok {
+		return 0, false
+	}
+	return metresPer[unit] / metresPer[to], true
+}
+
+// converts the values of a curve in the unit from to another, other curves are left alone
+func (c *Curve) convertLength(from, unit string) {
+	if normalizeName(c.Unit) != normalizeName(from) {
+		return
+	}
+	scale, ok := lengthScale(c.Unit, unit)
+	if !ok {
+		return
+	}
+	for i := range c.Data {
+		c.Data[i] *= scale
+	}
+	for _, values := range c.Array {
+		for i := range values {
+			values[i] *= scale
+		}
+	}
+	c.Unit = unit
+}
+
+// returns the rows whose index is between top and base, by increasing index
+func sortedRows(index []float64, top, base float64) []int {
+	var rows []int
+	for i, depth := range index {
+		if depth >= top && depth <= base {
+			rows = append(rows, i)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return index[rows[i]] < index[rows[j]] })
+	return rows
+}
+
+func reverseRows(rows []int) {
+	for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+		rows[i], rows[j] = rows[j], rows[i]
+	}
+}
+
+// returns the row closest to each depth of the grid, -1 beyond half a step outside the samples
+func nearestRows(depths []float64, rows []int, grid []float64, step float64) []int {
+	nearest := make([]int, len(grid))
+	for i, depth := range grid {
+		j := sort.SearchFloat64s(depths, depth)
+		if j > 0 && (j == len(depths) || depth-depths[j-1] <= depths[j]-depth) {
+			j--
+		}
+		nearest[i] = rows[j]
+		if depth < depths[0]-step/2 || depth > depths[len(depths)-1]+step/2 {
+			nearest[i] = -1
+		}
+	}
+	return nearest
+}
+
+// returns the rows around each depth of the grid and the weight of the upper one, -1 outside the samples
+func bracketRows(depths []float64, rows []int, grid []float64) (lower, upper []int, weights []float64) {
+	lower, upper, weights = make([]int, len(grid)), make([]int, len(grid)), make([]float64, len(grid))
+	for i, depth := range grid {
+		j := sort.SearchFloat64s(depths, depth)
+		switch {
+		case j < len(depths) && depths[j] == depth:
+			lower[i], upper[i] = rows[j], rows[j]
+		case j == 0 || j == len(depths):
+			lower[i], upper[i] = -1, -1
+		default:
+			lower[i], upper[i] = rows[j-1], rows[j]
+			weights[i] = (depth - depths[j-1]) / (depths[j] - depths[j-1])
+		}
+	}
+	return lower, upper, weights
+}
+
+// returns the rows within half a step of each depth of the grid, by increasing depth
+func binRows(depths []float64, rows []int, grid []float64, step float64) [][]int {
+	bins := make([][]int, len(grid))
+	for i, depth := range grid {
+		from := sort.SearchFloat64s(depths, depth-step/2)
+		to := sort.SearchFloat64s(depths, depth+step/2)
+		if i == len(grid)-1 {
+			// the last bin takes its upper edge
+			for to < len(depths) && depths[to] <= depth+step/2 {
+				to++
+			}
+		}
+		bins[i] = rows[from:to]
+	}
+	return bins
+}
+
+// returns the curve at the rows, null where the row is -1
+func (c Curve) pick(rows []int) Curve {
+	out := c
+	out.Data, out.Text, out.Array = nil, nil, nil
+	for _, row := range rows {
+		switch {
+		case c.Text != nil:
+			value := ""
+			if row >= 0 {
+				value = c.Text[row]
+			}
+			out.Text = append(out.Text, value)
+		case c.Array != nil:
+			value := make(curveValues, len(c.Array[0]))
+			if row >= 0 {
+				copy(value, c.Array[row])
+			} else {
+				for i := range value {
+					value[i] = math.NaN()
+				}
+			}
+			out.Array = append(out.Array, value)
+		default:
+			value := math.NaN()
+			if row >= 0 {
+				value = c.Data[row]
+			}
+			out.Data = append(out.Data, value)
+		}
+	}
+	return out
+}
+
+// returns the curve interpolated between the lower and upper rows, text takes the nearest row
+func (c Curve) interpolate(lower, upper []int, weights []float64, nearest []int) Curve {
+	if c.Text != nil {
+		return c.pick(nearest)
+	}
+	lerp := func(a, b, weight float64) float64 {
+		if weight == 0 {
+			return a
+		}
+		return a + (b-a)*weight
+	}
+
+	out := c
+	out.Data, out.Array = nil, nil
+	for i := range lower {
+		switch {
+		case c.Array != nil:
+			value := make(curveValues, len(c.Array[0]))
+			for k := range value {
+				value[k] = math.NaN()
+				if lower[i] >= 0 {
+					value[k] = lerp(c.Array[lower[i]][k], c.Array[upper[i]][k], weights[i])
+				}
+			}
+			out.Array = append(out.Array, value)
+		default:
+			value := math.NaN()
+			if lower[i] >= 0 {
+				value = lerp(c.Data[lower[i]], c.Data[upper[i]], weights[i])
+			}
+			out.Data = append(out.Data, value)
+		}
+	}
+	return out
+}
+
+// returns two rows per bin with the lowest and highest value in depth order, text takes the first row of the bin
+func (c Curve) extremes(bins [][]int) Curve {
+
+	out := c
+	out.Data, out.Text, out.Array = nil, nil, nil
+	for _, bin := range bins {
+		switch {
+		case c.Text != nil:
+			value := ""
+			if len(bin) > 0 {
+				value = c.Text[bin[0]]
+			}
+			out.Text = append(out.Text, value, value)
+		case c.Array != nil:
+			first, second := make(curveValues, len(c.Array[0])), make(curveValues, len(c.Array[0]))
+			values := make([]float64, len(bin))
+			for k := range first {
+				for j, row := range bin {
+					values[j] = c.Array[row][k]
+				}
+				first[k], second[k] = extremesOf(values)
+			}
+			out.Array = append(out.Array, first, second)
+		default:
+			values := make([]float64, len(bin))
+			for j, row := range bin {
+				values[j] = c.Data[row]
+			}
+			first, second := extremesOf(values)
+			out.Data = append(out.Data, first, second)
+		}
+	}
+	return out
+}
+
+// returns the lowest and highest of the values, in the order they come; NaN when all are null
+func extremesOf(values []float64) (first, second float64) {
+	low, high := -1, -1
+	for i, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		if low < 0 || v < values[low] {
+			low = i
+		}
+		if high < 0 || v > values[high] {
+			high = i
+		}
+	}
+	switch {
+	case low < 0:
+		return math.NaN(), math.NaN()
+	case low <= high:
+		return values[low], values[high]
+	default:
+		return values[high], values[low]
+	}
+}
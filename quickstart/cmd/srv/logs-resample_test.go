@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// gamma ray of ten metres, a spike at 104 m and a null at 107 m
+var windowSamples = map[int]float64{100: 0, 101: 10, 102: 20, 103: 30, 104: 500, 105: 50, 106: 60, 107: -999.25, 108: 80, 109: 90, 110: 100}
+
+// a LAS 2.0 file of the samples, logged down or, when descending, up the well
+func windowLAS(descending bool) string {
+	var b strings.Builder
+	start, stop, step := 100, 110, 1
+	if descending {
+		start, stop, step = 110, 100, -1
+	}
+	fmt.Fprintf(&b, "~V\nVERS. 2.0 :\nWRAP. NO :\n~W\nSTRT.M %d :\nSTOP.M %d :\nSTEP.M %d :\nNULL. -999.25 :\n", start, stop, step)
+	b.WriteString("~C\nDEPT.M : DEPTH\nGR.GAPI : GAMMA RAY\n~A\n")
+	for depth := start; depth != stop+step; depth += step {
+		fmt.Fprintf(&b, "%d %g\n", depth, windowSamples[depth])
+	}
+	return b.String()
+}
+
+// formats values to three decimals, nulls as null
+func valuesString(values curveValues) string {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		fields[i] = "null"
+		if !math.IsNaN(v) {
+			fields[i] = fmt.Sprintf("%.3f", v)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+func reversed(s string) string {
+	fields := strings.Fields(s)
+	reverse := make([]string, len(fields))
+	for i, f := range fields {
+		reverse[len(fields)-1-i] = f
+	}
+	return strings.Join(reverse, " ")
+}
+
+/*
+	The windows are applied to the log recorded down the well and to the
+	same log recorded up it, which must give the same rows in the opposite
+	order
+*/
+func TestApplyWindow(t *testing.T) {
+
+	tests := []struct {
+		query      string
+		depth, gr  string // of the log recorded down the well
+		samples    int
+		depthUnits string
+	}{
+		{query: "top=102&base=106",
+			depth: "102.000 103.000 104.000 105.000 106.000", gr: "20.000 30.000 500.000 50.000 60.000", samples: 5},
+		{query: "top=107.5",
+			depth: "108.000 109.000 110.000", gr: "80.000 90.000 100.000", samples: 3},
+		{query: "step=2&method=nearest",
+			depth: "100.000 102.000 104.000 106.000 108.000 110.000", gr: "0.000 20.000 500.000 60.000 80.000 100.000", samples: 6},
+		// a grid point next to a null interpolates to null
+		{query: "step=2.5",
+			depth: "100.000 102.500 105.000 107.500 110.000", gr: "0.000 25.000 50.000 null 100.000", samples: 5},
+		// outside the samples: linear has no value, nearest none beyond half a step
+		{query: "step=0.5&top=99&base=101&method=linear",
+			depth: "99.000 99.500 100.000 100.500 101.000", gr: "null null 0.000 5.000 10.000", samples: 5},
+		{query: "step=0.5&top=99&base=101&method=nearest",
+			depth: "99.000 99.500 100.000 100.500 101.000", gr: "null null 0.000 0.000 10.000", samples: 5},
+		// two rows per step, the lowest and highest within half a step, so the spike and the null survive
+		{query: "step=4&method=minmax",
+			depth: "100.000 100.000 104.000 104.000 108.000 108.000", gr: "0.000 10.000 20.000 500.000 60.000 100.000", samples: 6},
+		{query: "depth_unit=ft&top=330&base=345", depthUnits: "ft",
+			depth: "331.365 334.646 337.927 341.207 344.488", gr: "10.000 20.000 30.000 500.000 50.000", samples: 5},
+		{query: "depth_unit=ft&step=10&top=330&base=350&method=nearest", depthUnits: "ft",
+			depth: "330.000 340.000 350.000", gr: "10.000 500.000 60.000", samples: 3},
+	}
+
+	for _, tt := range tests {
+		for _, descending := range []bool{false, true} {
+			name := tt.query
+			if descending {
+				name += " up the well"
+			}
+			t.Run(name, func(t *testing.T) {
+				query, _ := url.ParseQuery(tt.query)
+				window, err := parseLogWindow(query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				log, err := parseLAS(strings.NewReader(windowLAS(descending)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := log.applyWindow(window); err != nil {
+					t.Fatal(err)
+				}
+
+				depth, gr := tt.depth, tt.gr
+				if descending {
+					depth, gr = reversed(depth), reversed(gr)
+				}
+				if got := valuesString(log.Curves[0].Data); got != depth {
+					t.Errorf("depths\n got %s\nwant %s", got, depth)
+				}
+				if got := valuesString(log.Curves[1].Data); got != gr {
+					t.Errorf("gamma ray\n got %s\nwant %s", got, gr)
+				}
+				if window.Samples != tt.samples {
+					t.Errorf("%d samples, want %d", window.Samples, tt.samples)
+				}
+				if tt.depthUnits != "" && (log.Curves[0].Unit != tt.depthUnits || log.Well.IndexUnit != tt.depthUnits) {
+					t.Errorf("index in %s and %s, want %s", log.Curves[0].Unit, log.Well.IndexUnit, tt.depthUnits)
+				}
+			})
+		}
+	}
+}
+
+// the other data sets keep the rows in the window, in the direction of the log, with depths converted
+func TestApplyWindowTables(t *testing.T) {
+
+	log, err := parseLAS(strings.NewReader(las30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	window, err := parseLogWindow(url.Values{"top": {"1770"}, "base": {"2000"}, "depth_unit": {"ft"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := log.applyWindow(window); err != nil {
+		t.Fatal(err)
+	}
+
+	// the Log data set is recorded up the well
+	tables := map[string]LogTable{}
+	for _, table := range log.Tables {
+		tables[table.Name] = table
+	}
+	tops := tables["Tops"]
+	if got := valuesString(tops.Curves[0].Data) + " / " + valuesString(tops.Curves[1].Data); got != "1968.504 1871.719 1789.698 / 2132.546 null 1871.719" {
+		t.Errorf("tops %s", got)
+	}
+	if got := strings.Join(tops.Curves[2].Text, "|"); got != "|Colorado|Viking, upper" || tops.Curves[1].Unit != "ft" {
+		t.Errorf("tops %q in %s", got, tops.Curves[1].Unit)
+	}
+	// permeability is not a length
+	core := tables["Core[1]"]
+	if got := valuesString(core.Curves[0].Data) + " / " + valuesString(core.Curves[1].Data); got != "1774.934 1771.654 / 12.300 45.100" {
+		t.Errorf("core %s", got)
+	}
+	if got := valuesString(tables["Core[2]"].Curves[0].Data); got != "" {
+		t.Errorf("core below the window %s", got)
+	}
+}
+
+// only the curves in the unit of the index are depths, TEMP.F is in Fahrenheit and not in feet
+func TestConvertDepths(t *testing.T) {
+
+	log, err := parseLAS(strings.NewReader("~V\nVERS. 2.0 :\nWRAP. NO :\n~W\nSTRT.M 100 :\nSTOP.M 101 :\nSTEP.M 1 :\nNULL. -999.25 :\n" +
+		"~C\nDEPT.M : DEPTH\nTEMP.F : TEMPERATURE\nTVD.M : TRUE VERTICAL DEPTH\n~A\n100 150 99.5\n101 151 100.5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := log.applyWindow(&LogWindow{DepthUnit: "ft"}); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"ft 328.084 331.365", "F 150.000 151.000", "ft 326.444 329.724"} {
+		if got := log.Curves[i].Unit + " " + valuesString(log.Curves[i].Data); got != want {
+			t.Errorf("%s is %s, want %s", log.Curves[i].Mnemonic, got, want)
+		}
+	}
+}
+
+func TestParseLogWindow(t *testing.T) {
+
+	for _, c := range []struct {
+		query, method, err string
+	}{
+		{query: ""},
+		{query: "curves=GR"},
+		{query: "step=1", method: resampleLinear},
+		{query: "top=1&step=1&method=minmax", method: resampleMinMax},
+		{query: "depth_unit=Feet", method: ""},
+		{query: "method=linear", err: "method linear needs a step"},
+		{query: "step=0", err: "step must be a number above 0"},
+		{query: "step=-1", err: "step must be a number above 0"},
+		{query: "top=NaN", err: "top must be a number"},
+		{query: "top=5&base=1", err: "top must not be below base"},
+		{query: "depth_unit=yd", err: `depth_unit "yd" is not m, ft or usft`},
+		{query: "method=cubic&step=1", err: "method must be nearest, linear or minmax"},
+	} {
+		query, _ := url.ParseQuery(c.query)
+		window, err := parseLogWindow(query)
+		switch {
+		case c.err != "":
+			if err == nil || err.Error() != c.err {
+				t.Errorf("%q: error %v, want %q", c.query, err, c.err)
+			}
+		case err != nil:
+			t.Errorf("%q: %s", c.query, err)
+		case c.query == "" || c.query == "curves=GR":
+			if window != nil {
+				t.Errorf("%q: window %+v without window parameters", c.query, window)
+			}
+		case window.Method != c.method:
+			t.Errorf("%q: method %q, want %q", c.query, window.Method, c.method)
+		}
+	}
+
+	log, err := parseLAS(strings.NewReader(windowLAS(false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := log.applyWindow(&LogWindow{Step: 1e-6, Method: resampleLinear}); err == nil {
+		t.Error("resampled ten metres every micrometre")
+	}
+}
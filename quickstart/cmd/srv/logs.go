@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// well log files bigger than this are not parsed
+const maxLogSize = 256 << 20
+
+// logResponse is the body of /logs
+type logResponse struct {
+	SRN      string `json:"srn"`
+	Filename string `json:"filename"`
+	*LogFile
+
+	// the depth window and resampling applied to the curves, if any
+	Window *LogWindow `json:"window,omitempty"`
+}
+
+/*
+	Logs handler fetches the LAS file of an SRN and returns its header and
+	curves as JSON, each curve with its values in "data" ("text" for string
+	curves, "array" for array curves) and nulls for the NULL value of the
+	file. ?curves= (comma separated or repeated) keeps only the named curves
+	besides the index. The other data sets of a LAS 3.0 file, such as tops
+	or core, come as "tables" with their own curves.
+
+	?top= and ?base= keep the rows between two depths, and ?step= resamples
+	the curves on a regular grid with ?method=linear (the default), nearest
+	or minmax (the lowest and highest sample of each step, for plotting).
+	Depths are in ?depth_unit= (m, ft or usft), which the index is converted
+	to first, or in the unit of the file:
+
+	http://localhost:8080/logs?srn=srn:file/las2:83120238df6f11e9b5dfb1a6ac04af7f:1&curves=GR,RHOB
+	http://localhost:8080/logs?srn=srn:file/las2:83120238df6f11e9b5dfb1a6ac04af7f:1&top=1500&base=2500&step=1&method=minmax&depth_unit=ft
+*/
+func handleLogs(w http.ResponseWriter, r *http.Request) {
+
+	SRN := r.URL.Query().Get("srn")
+	if SRN == "" {
+		writeFetchError(w, http.StatusBadRequest, "srn is required")
+		return
+	}
+	window, err := parseLogWindow(r.URL.Query())
+	if err != nil {
+		writeFetchError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	fileReq := FileRequest{SRNS: []string{SRN}}
+	if err := fileReq.setRegion(r); err != nil {
+		writeFetchError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	data, entry, err := readFile(r.Context(), fileReq, maxLogSize)
+	if err != nil {
+		writeDownloadError(w, SRN, err)
+		return
+	}
+
+	lasFile, err := parseLAS(bytes.NewReader(data))
+	if err != nil {
+		writeFetchError(w, http.StatusUnprocessableEntity, entry.Filename+": "+err.Error())
+		return
+	}
+
+	var names []string
+	for _, param := range r.URL.Query()["curves"] {
+		names = append(names, splitList(param)...)
+	}
+	if err := lasFile.selectCurves(names); err != nil {
+		writeFetchError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if window != nil {
+		if err := lasFile.applyWindow(window); err != nil {
+			writeFetchError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, logResponse{SRN: SRN, Filename: entry.Filename, LogFile: lasFile, Window: window})
+}
+
+// keeps the index curve and the named curves, without case, in file order
+func (l *LogFile) selectCurves(names []string) error {
+
+	if len(names) == 0 {
+		return nil
+	}
+	if len(l.Curves) == 0 {
+		return errors.New("the file has no log curves to select from")
+	}
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[strings.ToUpper(name)] = false
+	}
+
+	selected := []Curve{l.Curves[0]}
+	for _, c := range l.Curves {
+		mnemonic := strings.ToUpper(c.Mnemonic)
+		if _, ok := wanted[mnemonic]; !ok {
+			continue
+		}
+		if !wanted[mnemonic] && mnemonic != strings.ToUpper(l.Curves[0].Mnemonic) {
+			selected = append(selected, c)
+		}
+		wanted[mnemonic] = true
+	}
+
+	var missing []string
+	for _, name := range names {
+		if !wanted[strings.ToUpper(name)] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		available := make([]string, len(l.Curves))
+		for i, c := range l.Curves {
+			available[i] = c.Mnemonic
+		}
+		return fmt.Errorf("no curve %s in the file, it has %s", strings.Join(missing, ", "), strings.Join(available, ", "))
+	}
+	l.Curves = selected
+	return nil
+}
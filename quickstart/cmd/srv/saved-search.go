@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// saved searches shared by the handlers, loaded in main
+var savedSearches *savedSearchStore
+
+// names are used in URLs, so keep them simple
+var savedSearchNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// SavedSearch is a named /find query kept in the local store
+type SavedSearch struct {
+	Name string `json:"name"`
+	SearchQuery
+	Updated time.Time `json:"updated"`
+}
+
+// savedSearchStore keeps saved searches in memory and
+// writes them to a JSON file on every change
+type savedSearchStore struct {
+	mu       sync.RWMutex
+	path     string
+	searches map[string]SavedSearch
+}
+
+/*
+	Function opens the saved search store, a missing file means
+	there are no saved searches yet and it is created on first save;
+	a file that cannot be read gives no store, so it is never overwritten
+*/
+func openSavedSearchStore(path string) (*savedSearchStore, error) {
+
+	store := &savedSearchStore{path: path, searches: map[string]SavedSearch{}}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []SavedSearch
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("reading %s: %s", path, err)
+	}
+	for _, s := range list {
+		store.searches[s.Name] = s
+	}
+	log.Printf("Loaded %d saved searches from %s", len(list), path)
+
+	return store, nil
+}
+
+// returns the saved search with the given name
+func (s *savedSearchStore) Get(name string) (SavedSearch, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	saved, ok := s.searches[name]
+	return saved, ok
+}
+
+// returns all saved searches sorted by name
+func (s *savedSearchStore) List() []SavedSearch {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]SavedSearch, 0, len(s.searches))
+	for _, saved := range s.searches {
+		list = append(list, saved)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// adds or replaces a saved search and persists the store
+func (s *savedSearchStore) Save(saved SavedSearch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, existed := s.searches[saved.Name]
+	s.searches[saved.Name] = saved
+	if err := s.write(); err != nil {
+		// keep memory in sync with the file
+		if existed {
+			s.searches[saved.Name] = previous
+		} else {
+			delete(s.searches, saved.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// removes a saved search, reports false when it did not exist
+func (s *savedSearchStore) Delete(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved, ok := s.searches[name]
+	if !ok {
+		return false, nil
+	}
+	delete(s.searches, name)
+	if err := s.write(); err != nil {
+		s.searches[name] = saved
+		return true, err
+	}
+	return true, nil
+}
+
+// writes the store to a temporary file and renames it over the old one,
+// so a crash never leaves a half written file behind
+func (s *savedSearchStore) write() error {
+
+	list := make([]SavedSearch, 0, len(s.searches))
+	for _, saved := range s.searches {
+		list = append(list, saved)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	data, err := json.MarshalIndent(list, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+/*
+	Saved searches handler lists saved searches on GET
+	and saves the search from the JSON body on POST
+*/
+func handleSavedSearches(w http.ResponseWriter, r *http.Request) {
+
+	if savedSearches == nil {
+		http.Error(w, "saved searches are not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, savedSearches.List())
+
+	case http.MethodPost:
+		var saved SavedSearch
+		if err := json.NewDecoder(r.Body).Decode(&saved); err != nil {
+			http.Error(w, "Invalid saved search JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !savedSearchNamePattern.MatchString(saved.Name) {
+			http.Error(w, "name must be 1-64 letters, digits, '_', '.' or '-'", http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(saved.Text) == "" && len(saved.Attributes) == 0 {
+			http.Error(w, "text or attributes must be given", http.StatusBadRequest)
+			return
+		}
+		if err := saved.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		saved.Updated = time.Now().UTC()
+
+		if err := savedSearches.Save(saved); err != nil {
+			log.Printf("Saving search %s failed with %s", saved.Name, err)
+			http.Error(w, "Saving search failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, saved)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+/*
+	Saved search handler returns a single saved search on GET
+	and removes it on DELETE, the name is the last path segment
+*/
+func handleSavedSearch(w http.ResponseWriter, r *http.Request) {
+
+	if savedSearches == nil {
+		http.Error(w, "saved searches are not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/searches/")
+
+	switch r.Method {
+	case http.MethodGet:
+		saved, ok := savedSearches.Get(name)
+		if !ok {
+			http.Error(w, fmt.Sprintf("saved search %q not found", name), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, saved)
+
+	case http.MethodDelete:
+		found, err := savedSearches.Delete(name)
+		if err != nil {
+			log.Printf("Deleting search %s failed with %s", name, err)
+			http.Error(w, "Deleting search failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, fmt.Sprintf("saved search %q not found", name), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// operators supported in attribute conditions
+const (
+	opEqual = "eq"
+	opIn    = "in"
+	opRange = "range"
+)
+
+// well attributes that can be used by name in /find parameters,
+// any other field has to be given with its full path in a JSON body
+var wellAttributes = map[string]string{
+	"operator":  "data.Operator",
+	"field":     "data.Field",
+	"status":    "data.Status",
+	"country":   "data.Country",
+	"spud_date": "data.SpudDate",
+	"well_name": "data.WellName",
+}
+
+// Condition restricts a single attribute of the searched records
+type Condition struct {
+	Field  string   `json:"field"`
+	Op     string   `json:"op"`
+	Value  string   `json:"value,omitempty"`
+	Values []string `json:"values,omitempty"`
+	From   string   `json:"from,omitempty"`
+	To     string   `json:"to,omitempty"`
+}
+
+/*
+	Function reads attribute conditions from /find parameters:
+	operator=Shell is an equality, status=Active,Drilling an IN list
+	and spud_date_from=2005&spud_date_to=2010 an inclusive range, to the end
+	of 2010
+*/
+func conditionsFromValues(values url.Values) []Condition {
+
+	names := make([]string, 0, len(wellAttributes))
+	for name := range wellAttributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var conditions []Condition
+	for _, name := range names {
+		field := wellAttributes[name]
+
+		if value := values.Get(name); value != "" {
+			if parts := strings.Split(value, ","); len(parts) > 1 {
+				conditions = append(conditions, Condition{Field: field, Op: opIn, Values: parts})
+			} else {
+				conditions = append(conditions, Condition{Field: field, Op: opEqual, Value: value})
+			}
+		}
+
+		from, to := values.Get(name+"_from"), values.Get(name+"_to")
+		if from != "" || to != "" {
+			conditions = append(conditions, Condition{Field: field, Op: opRange, From: from, To: to})
+		}
+	}
+
+	return conditions
+}
+
+// checks the condition is complete, field aliases are resolved in place
+func (c *Condition) Validate() error {
+
+	if field, ok := wellAttributes[c.Field]; ok {
+		c.Field = field
+	}
+	if c.Field == "" || strings.ContainsAny(c.Field, " :\"()") {
+		return fmt.Errorf("invalid condition field %q", c.Field)
+	}
+
+	switch c.Op {
+	case opEqual:
+		if c.Value == "" {
+			return fmt.Errorf("condition on %s needs a value", c.Field)
+		}
+	case opIn:
+		if len(c.Values) == 0 {
+			return fmt.Errorf("condition on %s needs values", c.Field)
+		}
+	case opRange:
+		if c.From == "" && c.To == "" {
+			return fmt.Errorf("range on %s needs from or to", c.Field)
+		}
+	default:
+		return fmt.Errorf("unsupported operator %q on %s, use eq, in or range", c.Op, c.Field)
+	}
+
+	return nil
+}
+
+/*
+	Function serializes the condition to the query string syntax of the
+	Search API, e.g. data.SpudDate:["2005" TO "2010-12-31T23:59:59"]; the
+	upper bound of a date field is taken to the end of its year, month or
+	day, so the range takes in the whole of it
+*/
+func (c Condition) String() string {
+	switch c.Op {
+	case opIn:
+		quoted := make([]string, len(c.Values))
+		for i, v := range c.Values {
+			quoted[i] = quoteTerm(v)
+		}
+		return c.Field + ":(" + strings.Join(quoted, " OR ") + ")"
+	case opRange:
+		to := c.To
+		if isDateField(c.Field) {
+			to = endOfDate(to)
+		}
+		return c.Field + ":[" + rangeBound(c.From) + " TO " + rangeBound(to) + "]"
+	}
+	return c.Field + ":" + quoteTerm(c.Value)
+}
+
+// quotes a term so reserved characters in well names do not break the query
+func quoteTerm(term string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(strings.TrimSpace(term)) + `"`
+}
+
+// open range ends are written as *
+func rangeBound(bound string) string {
+	if bound == "" {
+		return "*"
+	}
+	return quoteTerm(bound)
+}
+
+// OSDU names date attributes ...Date, like SpudDate or CreateDate
+func isDateField(field string) bool {
+	return strings.HasSuffix(strings.ToLower(field), "date")
+}
+
+// dates given to the year, month or day, by the layout they are written in and their length
+var partialDates = []struct {
+	layout              string
+	years, months, days int
+}{
+	{"2006", 1, 0, 0},
+	{"2006-01", 0, 1, 0},
+	{"2006-01-02", 0, 0, 1},
+}
+
+// returns the last second of a date given to the year, month or day, other values as they are
+func endOfDate(bound string) string {
+	bound = strings.TrimSpace(bound)
+	for _, d := range partialDates {
+		if start, err := time.Parse(d.layout, bound); err == nil {
+			return start.AddDate(d.years, d.months, d.days).Add(-time.Second).Format("2006-01-02T15:04:05")
+		}
+	}
+	return bound
+}
+
+/*
+	Function combines the full text term with the attribute conditions,
+	all of them have to match
+*/
+func buildFullText(text string, conditions []Condition) string {
+
+	var parts []string
+	if text = strings.TrimSpace(text); text != "" && (text != "*" || len(conditions) == 0) {
+		if len(conditions) > 0 {
+			text = "(" + text + ")"
+		}
+		parts = append(parts, text)
+	}
+	for _, c := range conditions {
+		parts = append(parts, c.String())
+	}
+
+	return strings.Join(parts, " AND ")
+}
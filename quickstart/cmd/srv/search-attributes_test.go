@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestConditionString(t *testing.T) {
+	for _, c := range []struct {
+		condition Condition
+		want      string
+	}{
+		{Condition{Field: "data.Operator", Op: opEqual, Value: `NAM "B.V."`}, `data.Operator:"NAM \"B.V.\""`},
+		{Condition{Field: "data.Status", Op: opIn, Values: []string{"Active", "Drilling"}}, `data.Status:("Active" OR "Drilling")`},
+		// the upper bound of a date takes in the whole year, month or day
+		{Condition{Field: "data.SpudDate", Op: opRange, From: "2005", To: "2010"}, `data.SpudDate:["2005" TO "2010-12-31T23:59:59"]`},
+		{Condition{Field: "data.SpudDate", Op: opRange, From: "2005-03", To: "2008-02"}, `data.SpudDate:["2005-03" TO "2008-02-29T23:59:59"]`},
+		{Condition{Field: "data.CreateDate", Op: opRange, To: "2010-06-30"}, `data.CreateDate:[* TO "2010-06-30T23:59:59"]`},
+		{Condition{Field: "data.SpudDate", Op: opRange, From: "2010"}, `data.SpudDate:["2010" TO *]`},
+		{Condition{Field: "data.SpudDate", Op: opRange, To: "2010-06-30T12:00:00"}, `data.SpudDate:[* TO "2010-06-30T12:00:00"]`},
+		// other fields keep their bounds
+		{Condition{Field: "data.TotalDepth", Op: opRange, From: "1000", To: "2010"}, `data.TotalDepth:["1000" TO "2010"]`},
+	} {
+		if got := c.condition.String(); got != c.want {
+			t.Errorf("%+v\n got %s\nwant %s", c.condition, got, c.want)
+		}
+	}
+}
+
+func TestConditionsFromValues(t *testing.T) {
+	for _, c := range []struct {
+		query string
+		want  []Condition
+	}{
+		{"operator=Shell", []Condition{{Field: "data.Operator", Op: opEqual, Value: "Shell"}}},
+		{"status=Active,Drilling", []Condition{{Field: "data.Status", Op: opIn, Values: []string{"Active", "Drilling"}}}},
+		{"spud_date_from=2005&spud_date_to=2010", []Condition{{Field: "data.SpudDate", Op: opRange, From: "2005", To: "2010"}}},
+		{"spud_date_to=2010-06", []Condition{{Field: "data.SpudDate", Op: opRange, To: "2010-06"}}},
+		// conditions come in the order of the attribute names, unknown parameters are left to /find
+		{"well_name=A05&country=NL&operator=&depth=1000", []Condition{
+			{Field: "data.Country", Op: opEqual, Value: "NL"},
+			{Field: "data.WellName", Op: opEqual, Value: "A05"},
+		}},
+		{"text=A05", nil},
+	} {
+		values, _ := url.ParseQuery(c.query)
+		if got := conditionsFromValues(values); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: %+v, want %+v", c.query, got, c.want)
+		}
+	}
+}
+
+func TestConditionValidate(t *testing.T) {
+	for _, c := range []struct {
+		condition Condition
+		field     string // after aliases are resolved, empty when the condition is invalid
+	}{
+		{Condition{Field: "operator", Op: opEqual, Value: "Shell"}, "data.Operator"},
+		{Condition{Field: "data.Basin", Op: opIn, Values: []string{"North Sea"}}, "data.Basin"},
+		{Condition{Field: "spud_date", Op: opRange, To: "2010"}, "data.SpudDate"},
+		{Condition{Field: "", Op: opEqual, Value: "Shell"}, ""},
+		{Condition{Field: "data.Operator OR data.Field", Op: opEqual, Value: "Shell"}, ""},
+		{Condition{Field: "data.Operator:Shell", Op: opEqual, Value: "Shell"}, ""},
+		{Condition{Field: "operator", Op: opEqual}, ""},
+		{Condition{Field: "status", Op: opIn}, ""},
+		{Condition{Field: "spud_date", Op: opRange}, ""},
+		{Condition{Field: "operator", Op: "like", Value: "Sh*"}, ""},
+	} {
+		condition := c.condition
+		err := condition.Validate()
+		if c.field == "" {
+			if err == nil {
+				t.Errorf("%+v is valid", c.condition)
+			}
+		} else if err != nil || condition.Field != c.field {
+			t.Errorf("%+v: field %s, %v", c.condition, condition.Field, err)
+		}
+	}
+}
+
+func TestEndOfDate(t *testing.T) {
+	for bound, want := range map[string]string{
+		"2010":                "2010-12-31T23:59:59",
+		" 2010 ":              "2010-12-31T23:59:59",
+		"2010-12":             "2010-12-31T23:59:59",
+		"2012-02":             "2012-02-29T23:59:59",
+		"2010-04":             "2010-04-30T23:59:59",
+		"2010-12-31":          "2010-12-31T23:59:59",
+		"2012-02-28":          "2012-02-28T23:59:59",
+		"2010-06-30T12:00:00": "2010-06-30T12:00:00",
+		"":                    "",
+		"yesterday":           "yesterday",
+	} {
+		if got := endOfDate(bound); got != want {
+			t.Errorf("%q: %s, want %s", bound, got, want)
+		}
+	}
+}
+
+func TestBuildFullText(t *testing.T) {
+	spud := Condition{Field: "data.SpudDate", Op: opRange, From: "2005", To: "2010"}
+	shell := Condition{Field: "data.Operator", Op: opEqual, Value: "Shell"}
+	for _, c := range []struct {
+		text       string
+		conditions []Condition
+		want       string
+	}{
+		{"A05", nil, "A05"},
+		{" * ", nil, "*"},
+		{"", nil, ""},
+		// a match-all term is left out when attributes narrow the search
+		{"*", []Condition{shell}, `data.Operator:"Shell"`},
+		{"", []Condition{shell}, `data.Operator:"Shell"`},
+		{"A05 OR A06", []Condition{shell, spud}, `(A05 OR A06) AND data.Operator:"Shell" AND data.SpudDate:["2005" TO "2010-12-31T23:59:59"]`},
+	} {
+		if got := buildFullText(c.text, c.conditions); got != c.want {
+			t.Errorf("%q %+v\n got %s\nwant %s", c.text, c.conditions, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// export formats supported by the find handler
+const (
+	formatJSON    = "json"
+	formatCSV     = "csv"
+	formatNDJSON  = "ndjson"
+	formatGeoJSON = "geojson"
+)
+
+// content type written for each export format
+var formatContentTypes = map[string]string{
+	formatJSON:    "application/json",
+	formatCSV:     "text/csv; charset=utf-8",
+	formatNDJSON:  "application/x-ndjson",
+	formatGeoJSON: "application/geo+json",
+}
+
+// media types accepted in the Accept header for each export format
+var acceptFormats = map[string]string{
+	"application/json":     formatJSON,
+	"text/csv":             formatCSV,
+	"application/x-ndjson": formatNDJSON,
+	"application/ndjson":   formatNDJSON,
+	"application/geo+json": formatGeoJSON,
+}
+
+// paths inside a search result where a well location (WGS84) can be found
+var locationPaths = []struct{ lon, lat string }{
+	{"data.Data.IndividualTypeProperties.SpatialLocation.Wgs84Coordinates.features.0.geometry.coordinates.0",
+		"data.Data.IndividualTypeProperties.SpatialLocation.Wgs84Coordinates.features.0.geometry.coordinates.1"},
+	{"data.Longitude", "data.Latitude"},
+	{"data.WGS84Longitude", "data.WGS84Latitude"},
+	{"data.lon", "data.lat"},
+}
+
+// flush the response to the client every so many rows
+const exportFlushRows = 100
+
+/*
+	Function picks the export format for the find request,
+	the "format" parameter wins over the Accept header and
+	JSON is returned when nothing else matches
+*/
+func negotiateFormat(r *http.Request) (string, error) {
+
+	if format := strings.ToLower(r.URL.Query().Get("format")); format != "" {
+		if _, ok := formatContentTypes[format]; !ok {
+			return "", fmt.Errorf("unsupported format %q, use json, csv, ndjson or geojson", format)
+		}
+		return format, nil
+	}
+
+	// the supported media type with the highest q wins, the first listed on a tie
+	best, bestQ := formatJSON, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if format, ok := acceptFormats[mediaType]; ok {
+			if q := quality(params[1:]); q > bestQ {
+				best, bestQ = format, q
+			}
+		}
+	}
+
+	return best, nil
+}
+
+// returns the q of media type parameters, 1 when it is missing and 0 when it can not be read
+func quality(params []string) float64 {
+	for _, p := range params {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) == 2 && strings.ToLower(kv[0]) == "q" {
+			q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+			if err != nil || q < 0 || q > 1 {
+				return 0
+			}
+			return q
+		}
+	}
+	return 1
+}
+
+/*
+	Function decodes the Search API response one result at a time
+	and passes each result to fn, so the whole response body
+	is never held in memory
+*/
+func forEachResult(body io.Reader, fn func(gjson.Result) error) error {
+
+	dec := json.NewDecoder(body)
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		// anything but results (e.g. aggregations) is skipped
+		if key, _ := tok.(string); key != "results" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := expectDelim(dec, '['); err != nil {
+			return err
+		}
+		for dec.More() {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return err
+			}
+			if err := fn(gjson.ParseBytes(raw)); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reads the next token and checks it is the expected delimiter
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != want {
+		return fmt.Errorf("unexpected token %v in search response, want %v", tok, want)
+	}
+	return nil
+}
+
+/*
+	Function extracts the WGS84 well location from a search result,
+	ok is false when the result carries no location
+*/
+func getLocation(result gjson.Result) (lon, lat float64, ok bool) {
+	for _, p := range locationPaths {
+		lonValue, latValue := result.Get(p.lon), result.Get(p.lat)
+		if lonValue.Exists() && latValue.Exists() {
+			return lonValue.Float(), latValue.Float(), true
+		}
+	}
+	return 0, 0, false
+}
+
+// searchHit is a single search result, scored in fuzzy mode
+type searchHit struct {
+	Result gjson.Result
+	Score  *float64
+}
+
+// resultWriter writes search results to the client in an export format
+type resultWriter interface {
+	WriteResult(hit searchHit) error
+	Close() error
+}
+
+/*
+	Function streams search results from the Search API response body
+	to the client in the requested export format
+*/
+func exportResults(w http.ResponseWriter, format string, body io.Reader) error {
+	return writeExport(w, format, false, func(fn func(searchHit) error) error {
+		return forEachResult(body, func(result gjson.Result) error {
+			return fn(searchHit{Result: result})
+		})
+	})
+}
+
+// writes already collected (and ranked) search hits in the export format
+func exportHits(w http.ResponseWriter, format string, hits []searchHit) error {
+	return writeExport(w, format, true, func(fn func(searchHit) error) error {
+		for _, hit := range hits {
+			if err := fn(hit); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+/*
+	Function sets the export headers and passes every hit produced
+	by each to the writer for the format, flushing as it goes
+*/
+func writeExport(w http.ResponseWriter, format string, scored bool, each func(func(searchHit) error) error) error {
+
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	if format == formatCSV {
+		w.Header().Set("Content-Disposition", `attachment; filename="search.csv"`)
+	}
+
+	rw, err := newResultWriter(w, format, scored)
+	if err != nil {
+		return err
+	}
+
+	flusher, _ := w.(http.Flusher)
+	rows := 0
+
+	err = each(func(hit searchHit) error {
+		if err := rw.WriteResult(hit); err != nil {
+			return err
+		}
+		if rows++; flusher != nil && rows%exportFlushRows == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if closeErr := rw.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// creates a result writer for the export format, scored adds the similarity score
+func newResultWriter(w io.Writer, format string, scored bool) (resultWriter, error) {
+	switch format {
+	case formatCSV:
+		return newCSVWriter(w, scored)
+	case formatNDJSON:
+		return &ndjsonWriter{enc: json.NewEncoder(w)}, nil
+	case formatGeoJSON:
+		return newGeoJSONWriter(w)
+	}
+	return nil, errors.New("no streaming writer for format " + format)
+}
+
+// flat file row written by the CSV and NDJSON exports
+type exportRow struct {
+	ResourceType string   `json:"resource_type"`
+	Filename     string   `json:"filename"`
+	Srn          string   `json:"srn"`
+	Longitude    *float64 `json:"longitude,omitempty"`
+	Latitude     *float64 `json:"latitude,omitempty"`
+	Score        *float64 `json:"score,omitempty"`
+}
+
+/*
+	Function flattens a search result into one row per file,
+	a result without files still produces a single row
+*/
+func getRowsFromResult(hit searchHit) []exportRow {
+
+	result := hit.Result
+	base := exportRow{
+		ResourceType: result.Get("resource_type").String(),
+		Srn:          result.Get("srn").String(),
+		Score:        hit.Score,
+	}
+	if lon, lat, ok := getLocation(result); ok {
+		base.Longitude, base.Latitude = &lon, &lat
+	}
+
+	files := result.Get("files").Array()
+	if len(files) == 0 {
+		return []exportRow{base}
+	}
+
+	rows := make([]exportRow, 0, len(files))
+	for _, f := range files {
+		row := base
+		row.Filename = f.Get("filename").String()
+		row.Srn = f.Get("srn").String()
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// csvWriter writes one CSV line per file
+type csvWriter struct {
+	w      *csv.Writer
+	scored bool
+}
+
+func newCSVWriter(w io.Writer, scored bool) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w), scored: scored}
+	header := []string{"resource_type", "filename", "srn", "longitude", "latitude"}
+	if scored {
+		header = append(header, "score")
+	}
+	return cw, cw.w.Write(header)
+}
+
+func (cw *csvWriter) WriteResult(hit searchHit) error {
+	for _, row := range getRowsFromResult(hit) {
+		record := []string{row.ResourceType, row.Filename, row.Srn, "", ""}
+		if row.Longitude != nil {
+			record[3] = strconv.FormatFloat(*row.Longitude, 'f', -1, 64)
+			record[4] = strconv.FormatFloat(*row.Latitude, 'f', -1, 64)
+		}
+		if cw.scored {
+			score := ""
+			if row.Score != nil {
+				score = strconv.FormatFloat(*row.Score, 'f', 3, 64)
+			}
+			record = append(record, score)
+		}
+		if err := cw.w.Write(record); err != nil {
+			return err
+		}
+	}
+	// push the rows to the response so the client sees them as they come
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// ndjsonWriter writes one JSON object per line for each file
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (nw *ndjsonWriter) WriteResult(hit searchHit) error {
+	for _, row := range getRowsFromResult(hit) {
+		if err := nw.enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (nw *ndjsonWriter) Close() error {
+	return nil
+}
+
+// geoJSONWriter writes a FeatureCollection with a point feature
+// for each result that has a well location
+type geoJSONWriter struct {
+	w        io.Writer
+	features int
+	skipped  int
+}
+
+// GeoJSON point feature for a single search result
+type geoJSONFeature struct {
+	Type     string `json:"type"`
+	Geometry struct {
+		Type        string     `json:"type"`
+		Coordinates [2]float64 `json:"coordinates"`
+	} `json:"geometry"`
+	Properties struct {
+		ResourceType string       `json:"resource_type"`
+		Srn          string       `json:"srn"`
+		Score        *float64     `json:"score,omitempty"`
+		Files        []FileStruct `json:"files"`
+	} `json:"properties"`
+}
+
+func newGeoJSONWriter(w io.Writer) (*geoJSONWriter, error) {
+	_, err := io.WriteString(w, `{"type":"FeatureCollection","features":[`)
+	return &geoJSONWriter{w: w}, err
+}
+
+func (gw *geoJSONWriter) WriteResult(hit searchHit) error {
+
+	result := hit.Result
+	lon, lat, ok := getLocation(result)
+	if !ok {
+		gw.skipped++
+		return nil
+	}
+
+	var feature geoJSONFeature
+	feature.Type = "Feature"
+	feature.Geometry.Type = "Point"
+	feature.Geometry.Coordinates = [2]float64{lon, lat}
+	feature.Properties.ResourceType = result.Get("resource_type").String()
+	feature.Properties.Srn = result.Get("srn").String()
+	feature.Properties.Score = hit.Score
+	feature.Properties.Files = []FileStruct{}
+	for _, f := range result.Get("files").Array() {
+		feature.Properties.Files = append(feature.Properties.Files,
+			FileStruct{Filename: f.Get("filename").String(), Srn: f.Get("srn").String()})
+	}
+
+	buf, err := json.Marshal(feature)
+	if err != nil {
+		return err
+	}
+	if gw.features > 0 {
+		if _, err := io.WriteString(gw.w, ","); err != nil {
+			return err
+		}
+	}
+	gw.features++
+	_, err = gw.w.Write(buf)
+	return err
+}
+
+func (gw *geoJSONWriter) Close() error {
+	if gw.skipped > 0 {
+		log.Printf("GeoJSON export skipped %d results without location", gw.skipped)
+	}
+	_, err := io.WriteString(gw.w, "]}")
+	return err
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	for _, c := range []struct{ query, accept, want string }{
+		{"", "", formatJSON},
+		{"", "text/csv", formatCSV},
+		{"", "text/html, application/x-ndjson", formatNDJSON},
+		{"", "text/csv;q=0.1, application/json", formatJSON},
+		{"", "application/json;q=0.5, application/geo+json;q=0.9", formatGeoJSON},
+		{"", "application/ndjson, text/csv", formatNDJSON}, // the first listed on a tie
+		{"", "text/csv;q=0, */*", formatJSON},
+		{"", "text/csv; Q=0.8", formatCSV},
+		{"format=csv", "application/json", formatCSV},
+	} {
+		r := httptest.NewRequest("GET", "/find?"+c.query, nil)
+		r.Header.Set("Accept", c.accept)
+		if got, err := negotiateFormat(r); err != nil || got != c.want {
+			t.Errorf("%q with Accept %q: %q, %v, want %q", c.query, c.accept, got, err, c.want)
+		}
+	}
+}
+
+// a scored export has a score cell in every row, empty for a result without a score
+func TestCSVWriterScores(t *testing.T) {
+
+	var buf bytes.Buffer
+	cw, err := newCSVWriter(&buf, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	score := 0.8333
+	for _, hit := range []searchHit{
+		{Result: gjson.Parse(`{"resource_type":"master-data/Well","srn":"srn:master-data/Well:1:1"}`), Score: &score},
+		{Result: gjson.Parse(`{"resource_type":"master-data/Well","srn":"srn:master-data/Well:2:1"}`)},
+	} {
+		if err := cw.WriteResult(hit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "resource_type,filename,srn,longitude,latitude,score\n" +
+		"master-data/Well,,srn:master-data/Well:1:1,,,0.833\n" +
+		"master-data/Well,,srn:master-data/Well:2:1,,,\n"
+	if buf.String() != want {
+		t.Errorf("CSV\n%s\nwant\n%s", buf.String(), want)
+	}
+}
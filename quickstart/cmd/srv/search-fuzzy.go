@@ -0,0 +1,290 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/tidwall/gjson"
+)
+
+// hits scoring below this similarity are dropped in fuzzy mode
+const defaultMinScore = 0.6
+
+// cap on the spellings sent to the Search API for a single well name
+const maxNameVariants = 24
+
+// separators tried between the parts of a well name
+var nameSeparators = []string{"-", " ", "_"}
+
+// paths inside a search result where the well name can be found
+var wellNamePaths = []string{
+	"data.Data.IndividualTypeProperties.FacilityName",
+	"data.Data.IndividualTypeProperties.Name",
+	"data.WellName",
+	"data.FacilityName",
+	"data.Name",
+}
+
+/*
+	Function splits a well name into upper-cased letter and digit groups,
+	dropping separators and leading zeros, so "A05-01", "A5-1" and "a05 01"
+	all give [A 5 1]
+*/
+func wellNameTokens(name string) []string {
+
+	var tokens []string
+	var current []rune
+	var currentDigit bool
+
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		token := string(current)
+		if currentDigit {
+			token = strings.TrimLeft(token, "0")
+			if token == "" {
+				token = "0"
+			}
+		}
+		tokens = append(tokens, token)
+		current = current[:0]
+	}
+
+	for _, r := range name {
+		switch {
+		case unicode.IsDigit(r):
+			if !currentDigit {
+				flush()
+			}
+			currentDigit = true
+			current = append(current, r)
+		case unicode.IsLetter(r):
+			if currentDigit {
+				flush()
+			}
+			currentDigit = false
+			current = append(current, unicode.ToUpper(r))
+		default:
+			// separators end the current group
+			flush()
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+// returns the canonical form of a well name, e.g. "A-5-1" for "A05-01"
+func normalizeWellName(name string) string {
+	return strings.Join(wellNameTokens(name), "-")
+}
+
+/*
+	Function expands a well name into the spellings it is likely stored under,
+	combining unpadded and zero-padded numbers with the usual separators;
+	letters followed by a number are kept together as in "A05"
+*/
+func wellNameVariants(name string) []string {
+
+	tokens := wellNameTokens(name)
+	if len(tokens) == 0 {
+		return []string{name}
+	}
+
+	variants := []string{""}
+	for i, token := range tokens {
+
+		forms := []string{token}
+		if isDigits(token) && len(token) < 2 {
+			forms = append(forms, "0"+token)
+		}
+
+		seps := []string{""}
+		if i > 0 {
+			seps = nameSeparators
+			if !isDigits(tokens[i-1]) && isDigits(token) {
+				seps = []string{""}
+			}
+		}
+
+		var next []string
+		for _, v := range variants {
+			for _, sep := range seps {
+				for _, form := range forms {
+					next = append(next, v+sep+form)
+				}
+			}
+		}
+		variants = next
+	}
+
+	// the original spelling always comes first
+	seen := map[string]bool{name: true}
+	result := []string{name}
+	for _, v := range variants {
+		if !seen[v] && len(result) < maxNameVariants {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// builds a full text query matching any spelling of the well name
+func fuzzyFullText(name string) string {
+	variants := wellNameVariants(name)
+	for i, v := range variants {
+		variants[i] = strconv.Quote(v)
+	}
+	return strings.Join(variants, " OR ")
+}
+
+// edit distance between two strings counted in runes
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// smallest edit distance between the pattern and any substring of the text,
+// used for names embedded in file names such as "las2:.a05-01-log-8438"
+func substringDistance(pattern, text string) int {
+	rp, rt := []rune(pattern), []rune(text)
+	prev := make([]int, len(rt)+1)
+	cur := make([]int, len(rt)+1)
+	for i := 1; i <= len(rp); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rt); j++ {
+			cost := 1
+			if rp[i-1] == rt[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	best := len(rp)
+	for _, d := range prev {
+		if d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// similarity in [0, 1] of two normalized names, 1 is an exact match
+func nameSimilarity(query, name string) float64 {
+	longest := len([]rune(query))
+	if n := len([]rune(name)); n > longest {
+		longest = n
+	}
+	if longest == 0 {
+		return 0
+	}
+	return 1 - float64(levenshtein(query, name))/float64(longest)
+}
+
+/*
+	Function scores a search result against the normalized query, well names
+	are compared as a whole while file names only need to contain the query
+*/
+func scoreResult(query string, result gjson.Result) float64 {
+
+	best := 0.0
+	for _, path := range wellNamePaths {
+		if name := result.Get(path).String(); name != "" {
+			if s := nameSimilarity(query, normalizeWellName(name)); s > best {
+				best = s
+			}
+		}
+	}
+
+	if n := len([]rune(query)); n > 0 {
+		for _, f := range result.Get("files").Array() {
+			filename := normalizeWellName(f.Get("filename").String())
+			if s := 1 - float64(substringDistance(query, filename))/float64(n); s > best {
+				best = s
+			}
+		}
+	}
+
+	return best
+}
+
+/*
+	Function reads all results from the Search API response, scores them
+	against the well name and returns the hits above minScore, best first
+*/
+func rankFuzzyResults(name string, minScore float64, body io.Reader) ([]searchHit, error) {
+
+	query := normalizeWellName(name)
+
+	var hits []searchHit
+	err := forEachResult(body, func(result gjson.Result) error {
+		score := scoreResult(query, result)
+		if score >= minScore {
+			score = float64(int(score*1000+0.5)) / 1000
+			hits = append(hits, searchHit{Result: result, Score: &score})
+		}
+		return nil
+	})
+
+	sort.SliceStable(hits, func(i, j int) bool { return *hits[i].Score > *hits[j].Score })
+
+	return hits, err
+}
+
+// groups file names and srns of ranked hits by resource type for the JSON response
+func getFilesFromHits(hits []searchHit) map[string][]FileStruct {
+
+	SRNs := map[string][]FileStruct{}
+	for _, hit := range hits {
+		resourceType := hit.Result.Get("resource_type").String()
+		for _, f := range hit.Result.Get("files").Array() {
+			SRNs[resourceType] = append(SRNs[resourceType], FileStruct{
+				Filename: f.Get("filename").String(),
+				Srn:      f.Get("srn").String(),
+				Score:    hit.Score,
+			})
+		}
+	}
+	return SRNs
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestWellNameTokens(t *testing.T) {
+	for _, c := range []struct{ name, want string }{
+		{"A05-01", "A-5-1"},
+		{"a5 1", "A-5-1"},
+		{"A_005_001", "A-5-1"},
+		{"NLW-GT-01", "NLW-GT-1"},
+		{"8438", "8438"},
+		{"A00", "A-0"},
+		{"--", ""},
+	} {
+		if got := normalizeWellName(c.name); got != c.want {
+			t.Errorf("normalizeWellName(%q) = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestWellNameVariants(t *testing.T) {
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		// letters keep their number, the other parts take every separator
+		{"A05-01", []string{"A05-01", "A5-1", "A5-01", "A5 1", "A5 01", "A5_1", "A5_01", "A05-1", "A05 1", "A05 01", "A05_1", "A05_01"}},
+		{"A5", []string{"A5", "A05"}},
+		{"8438", []string{"8438"}},
+		{"--", []string{"--"}},
+	}
+	for _, tt := range tests {
+		if got := wellNameVariants(tt.name); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("variants of %q\n got %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+
+	if got := wellNameVariants("A-1-2-3-4"); len(got) != maxNameVariants || got[0] != "A-1-2-3-4" {
+		t.Errorf("%d variants starting with %q, want %d", len(got), got[0], maxNameVariants)
+	}
+	if got := fuzzyFullText("A5"); got != `"A5" OR "A05"` {
+		t.Errorf("full text %s", got)
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"A-5-1", "A-5-1", 0},
+		{"A-5-1", "A-5-2", 1},
+		{"A-5-1", "A-5-12", 1},
+		{"kitten", "sitting", 3},
+		{"ü", "u", 1}, // runes, not bytes
+	} {
+		if got := levenshtein(c.a, c.b); got != c.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+		if got := levenshtein(c.b, c.a); got != c.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", c.b, c.a, got, c.want)
+		}
+	}
+}
+
+func TestSubstringDistance(t *testing.T) {
+	for _, c := range []struct {
+		pattern, text string
+		want          int
+	}{
+		{"A-5-1", "LAS-2-A-5-1-LOG-8438", 0},
+		{"A-5-1", "A-6-1-LOG", 1},
+		{"A-5-1", "A-5", 2},
+		{"A-5-1", "", 5},
+		{"", "A-5-1", 0},
+	} {
+		if got := substringDistance(c.pattern, c.text); got != c.want {
+			t.Errorf("substringDistance(%q, %q) = %d, want %d", c.pattern, c.text, got, c.want)
+		}
+	}
+}
+
+func TestScoreResult(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		result string
+		want   float64
+	}{
+		{"exact well name", `{"data":{"Data":{"IndividualTypeProperties":{"FacilityName":"A05-01"}}}}`, 1},
+		{"one digit off", `{"data":{"WellName":"A05-02"}}`, 0.8},
+		{"best of the names", `{"data":{"WellName":"B07","Name":"a5-1"}}`, 1},
+		{"well name in a file name", `{"data":{"WellName":"A05-02"},"files":[{"filename":"las2:.a05-01-log-8438"}]}`, 1},
+		{"file name one digit off", `{"files":[{"filename":"a05-02-log.las"}]}`, 0.8},
+		{"no names", `{"resource_type":"master-data/Well"}`, 0},
+	}
+	for _, tt := range tests {
+		if got := scoreResult("A-5-1", gjson.Parse(tt.result)); !closeTo(got, tt.want) {
+			t.Errorf("%s: score %g, want %g", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRankFuzzyResults(t *testing.T) {
+
+	body := `{"results":[` +
+		`{"srn":"srn:master-data/Well:2:1","data":{"WellName":"A05-12"}},` +
+		`{"srn":"srn:master-data/Well:3:1","data":{"WellName":"B07"}},` +
+		`{"srn":"srn:master-data/Well:1:1","data":{"WellName":"A05-01"}}` +
+		`],"totalCount":3}`
+	hits, err := rankFuzzyResults("A5-1", defaultMinScore, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, hit := range hits {
+		got = append(got, fmt.Sprintf("%s %g", hit.Result.Get("srn").String(), *hit.Score))
+	}
+	// best first, rounded to three decimals, below the minimum score dropped
+	if want := "srn:master-data/Well:1:1 1|srn:master-data/Well:2:1 0.833"; strings.Join(got, "|") != want {
+		t.Errorf("hits %q, want %q", strings.Join(got, "|"), want)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SearchQuery holds the user facing parameters of a /find request
+type SearchQuery struct {
+	Text          string              `json:"text"`
+	ResourceTypes []string            `json:"resource_types,omitempty"`
+	Filters       map[string][]string `json:"filters,omitempty"`
+	Facets        []string            `json:"facets,omitempty"`
+	Attributes    []Condition         `json:"attributes,omitempty"`
+}
+
+// placeholders such as {well} in a saved query are filled from request parameters
+var placeholderPattern = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+/*
+	Function reads search parameters from the /find query string:
+	wellname, resource_type, filter=<field>:<value> and facet,
+	the last three can be repeated, and well attribute conditions
+*/
+func searchQueryFromValues(values url.Values) (SearchQuery, error) {
+
+	query := SearchQuery{
+		Text:          values.Get("wellname"),
+		ResourceTypes: values["resource_type"],
+		Facets:        values["facet"],
+		Attributes:    conditionsFromValues(values),
+	}
+
+	filters, err := parseFilters(values["filter"])
+	if err != nil {
+		return query, err
+	}
+	query.Filters = filters
+
+	return query, nil
+}
+
+// parses filter parameters of the form <field>:<value>
+func parseFilters(params []string) (map[string][]string, error) {
+	if len(params) == 0 {
+		return nil, nil
+	}
+	filters := map[string][]string{}
+	for _, param := range params {
+		i := strings.Index(param, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("filter %q must look like <field>:<value>", param)
+		}
+		field := param[:i]
+		filters[field] = append(filters[field], param[i+1:])
+	}
+	return filters, nil
+}
+
+/*
+	Function builds the query for a /find request, either from the query string
+	or from the saved search named by "saved" with request parameters overriding
+	the saved ones and filling its placeholders
+*/
+func findQuery(values url.Values) (SearchQuery, error) {
+
+	name := values.Get("saved")
+	if name == "" {
+		query, err := searchQueryFromValues(values)
+		if err != nil {
+			return query, err
+		}
+		return query, query.Validate()
+	}
+
+	if savedSearches == nil {
+		return SearchQuery{}, errors.New("saved searches are not available")
+	}
+	saved, ok := savedSearches.Get(name)
+	if !ok {
+		return SearchQuery{}, fmt.Errorf("saved search %q not found", name)
+	}
+
+	overrides, err := searchQueryFromValues(values)
+	if err != nil {
+		return SearchQuery{}, err
+	}
+
+	query, err := saved.SearchQuery.Override(overrides).Expand(values)
+	if err != nil {
+		return query, err
+	}
+	return query, query.Validate()
+}
+
+// reads the query from a JSON body posted to /find
+func searchQueryFromBody(body io.Reader) (SearchQuery, error) {
+	var query SearchQuery
+	if err := json.NewDecoder(body).Decode(&query); err != nil {
+		return query, fmt.Errorf("invalid search JSON: %s", err)
+	}
+	return query, query.Validate()
+}
+
+// checks the attribute conditions of the query
+func (q SearchQuery) Validate() error {
+	for i := range q.Attributes {
+		if err := q.Attributes[i].Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns a copy of the query with every parameter set in overrides replaced
+func (q SearchQuery) Override(overrides SearchQuery) SearchQuery {
+	if overrides.Text != "" {
+		q.Text = overrides.Text
+	}
+	if len(overrides.ResourceTypes) > 0 {
+		q.ResourceTypes = overrides.ResourceTypes
+	}
+	if len(overrides.Facets) > 0 {
+		q.Facets = overrides.Facets
+	}
+	if len(overrides.Filters) > 0 {
+		filters := map[string][]string{}
+		for field, values := range q.Filters {
+			filters[field] = values
+		}
+		for field, values := range overrides.Filters {
+			filters[field] = values
+		}
+		q.Filters = filters
+	}
+	if len(overrides.Attributes) > 0 {
+		// a condition replaces the saved conditions on the same field
+		replaced := map[string]bool{}
+		for _, c := range overrides.Attributes {
+			replaced[c.Field] = true
+		}
+		attributes := append([]Condition{}, overrides.Attributes...)
+		for _, c := range q.Attributes {
+			if !replaced[c.Field] && !replaced[wellAttributes[c.Field]] {
+				attributes = append(attributes, c)
+			}
+		}
+		q.Attributes = attributes
+	}
+	return q
+}
+
+/*
+	Function fills {name} placeholders in the text and filter values
+	with request parameters of the same name, every placeholder must be given
+*/
+func (q SearchQuery) Expand(values url.Values) (SearchQuery, error) {
+
+	missing := map[string]bool{}
+	expand := func(s string) string {
+		return placeholderPattern.ReplaceAllStringFunc(s, func(m string) string {
+			param := m[1 : len(m)-1]
+			if v := values.Get(param); v != "" {
+				return v
+			}
+			missing[param] = true
+			return m
+		})
+	}
+
+	q.Text = expand(q.Text)
+	if len(q.Filters) > 0 {
+		filters := map[string][]string{}
+		for field, fieldValues := range q.Filters {
+			for _, v := range fieldValues {
+				filters[field] = append(filters[field], expand(v))
+			}
+		}
+		q.Filters = filters
+	}
+	if len(q.Attributes) > 0 {
+		attributes := make([]Condition, len(q.Attributes))
+		for i, c := range q.Attributes {
+			c.Value, c.From, c.To = expand(c.Value), expand(c.From), expand(c.To)
+			c.Values = nil
+			for _, v := range q.Attributes[i].Values {
+				c.Values = append(c.Values, expand(v))
+			}
+			attributes[i] = c
+		}
+		q.Attributes = attributes
+	}
+
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return q, fmt.Errorf("missing parameters for saved search: %s", strings.Join(names, ", "))
+	}
+
+	return q, nil
+}
+
+// converts the query to a Search API request, defaults come from the well request
+func (q SearchQuery) SearchRequest() SearchRequest {
+
+	searchReq := newWellRequest()
+	searchReq.FullText = buildFullText(q.Text, q.Attributes)
+	if len(q.ResourceTypes) > 0 {
+		searchReq.Metadata.ResourceType = q.ResourceTypes
+	}
+	if len(q.Facets) > 0 {
+		searchReq.Facets = q.Facets
+	}
+	searchReq.Metadata.Filters = q.Filters
+
+	return searchReq
+}
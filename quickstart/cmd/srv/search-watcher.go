@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"golang.org/x/net/context"
+)
+
+// kinds of changes reported by the search watcher
+const (
+	eventAdded      = "added"
+	eventRemoved    = "removed"
+	eventNewVersion = "new-version"
+)
+
+// keep this many events for the /events endpoint
+const maxRecentEvents = 1000
+
+// events shared with the /events handler, filled by the watcher
+var recentEvents = newEventLog(maxRecentEvents)
+
+// last event ID handed out, IDs only grow so clients can poll with since
+var lastEventID int64
+
+// WatchEvent describes a record that appeared, disappeared
+// or changed its version between two runs of a watched search
+type WatchEvent struct {
+	ID          int64     `json:"id"`
+	Time        time.Time `json:"time"`
+	Search      string    `json:"search"`
+	Type        string    `json:"type"`
+	SRN         string    `json:"srn"`
+	PreviousSRN string    `json:"previous_srn,omitempty"`
+}
+
+// eventSink receives the events found by one watcher run
+type eventSink interface {
+	Emit(events []WatchEvent) error
+}
+
+// watchedSearch is a /find query the watcher re-runs, with the
+// SRNs it returned last time keyed by SRN without version
+type watchedSearch struct {
+	name     string
+	values   url.Values
+	previous map[string]string
+}
+
+// searchWatcher re-runs watched searches on a schedule
+// and emits the differences to its sinks
+type searchWatcher struct {
+	interval time.Duration
+	searches []*watchedSearch
+	sinks    []eventSink
+}
+
+/*
+	Function creates a watcher from a comma separated list of /find
+	query strings, e.g. "saved=well-logs&well=A05-01,wellname=A05-02"
+*/
+func newSearchWatcher(searches string, interval time.Duration, sinks ...eventSink) (*searchWatcher, error) {
+
+	watcher := &searchWatcher{interval: interval, sinks: sinks}
+
+	for _, s := range strings.Split(searches, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		values, err := url.ParseQuery(s)
+		if err != nil {
+			return nil, fmt.Errorf("watched search %q: %s", s, err)
+		}
+		watcher.searches = append(watcher.searches, &watchedSearch{name: s, values: values})
+	}
+
+	return watcher, nil
+}
+
+/*
+	Function runs all watched searches right away and then on every tick
+	until the context is cancelled, the first run only records the baseline
+*/
+func (sw *searchWatcher) Run(ctx context.Context) {
+
+	log.Printf("Watching %d searches every %s", len(sw.searches), sw.interval)
+
+	ticker := time.NewTicker(sw.interval)
+	defer ticker.Stop()
+
+	for {
+		for _, search := range sw.searches {
+			events, err := sw.check(search)
+			if err != nil {
+				log.Printf("Watched search %s failed with %s", search.name, err)
+				continue
+			}
+			sw.emit(events)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runs a single watched search and diffs its SRNs against the previous run
+func (sw *searchWatcher) check(search *watchedSearch) ([]WatchEvent, error) {
+
+	query, err := findQuery(search.values)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := postSearch(query.SearchRequest())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	current := map[string]string{}
+	err = forEachResult(resp.Body, func(result gjson.Result) error {
+		addSRN(current, result.Get("srn").String())
+		for _, f := range result.Get("files").Array() {
+			addSRN(current, f.Get("srn").String())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	previous := search.previous
+	search.previous = current
+	if previous == nil {
+		log.Printf("Watched search %s starts with %d records", search.name, len(current))
+		return nil, nil
+	}
+
+	return diffSRNs(search.name, previous, current), nil
+}
+
+/*
+	Function adds the SRN to the set keyed by the SRN without its version,
+	the highest version wins whatever order the results come in
+*/
+func addSRN(set map[string]string, srn string) {
+	if srn == "" {
+		return
+	}
+	base, version := splitSRNVersion(srn)
+	if kept, ok := set[base]; ok {
+		_, keptVersion := splitSRNVersion(kept)
+		n, _ := strconv.Atoi(version)
+		keptN, _ := strconv.Atoi(keptVersion)
+		if n <= keptN {
+			return
+		}
+	}
+	set[base] = srn
+}
+
+/*
+	Function splits the trailing version from an SRN,
+	e.g. "srn:file/csv:6dd13750df8611e9b5df4fa704076d5c:1"
+	gives "srn:file/csv:6dd13750df8611e9b5df4fa704076d5c" and "1"
+*/
+func splitSRNVersion(srn string) (string, string) {
+	i := strings.LastIndex(srn, ":")
+	if i < 0 {
+		return srn, ""
+	}
+	if _, err := strconv.Atoi(srn[i+1:]); err != nil && srn[i+1:] != "" {
+		return srn, ""
+	}
+	return srn[:i], srn[i+1:]
+}
+
+// compares two SRN sets and returns the events sorted by SRN
+func diffSRNs(search string, previous, current map[string]string) []WatchEvent {
+
+	var events []WatchEvent
+	now := time.Now().UTC()
+
+	for base, srn := range current {
+		old, ok := previous[base]
+		switch {
+		case !ok:
+			events = append(events, WatchEvent{Time: now, Search: search, Type: eventAdded, SRN: srn})
+		case old != srn:
+			events = append(events, WatchEvent{Time: now, Search: search, Type: eventNewVersion, SRN: srn, PreviousSRN: old})
+		}
+	}
+	for base, srn := range previous {
+		if _, ok := current[base]; !ok {
+			events = append(events, WatchEvent{Time: now, Search: search, Type: eventRemoved, SRN: srn})
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].SRN < events[j].SRN })
+	return events
+}
+
+// passes events to every sink, a failing sink does not stop the others
+func (sw *searchWatcher) emit(events []WatchEvent) {
+	if len(events) == 0 {
+		return
+	}
+	for i := range events {
+		events[i].ID = atomic.AddInt64(&lastEventID, 1)
+	}
+	for _, sink := range sw.sinks {
+		if err := sink.Emit(events); err != nil {
+			log.Printf("Emitting %d events failed with %s", len(events), err)
+		}
+	}
+}
+
+// logSink writes one log line per event
+type logSink struct{}
+
+func (logSink) Emit(events []WatchEvent) error {
+	for _, e := range events {
+		if e.PreviousSRN != "" {
+			log.Printf("Search %s: %s %s (was %s)", e.Search, e.Type, e.SRN, e.PreviousSRN)
+		} else {
+			log.Printf("Search %s: %s %s", e.Search, e.Type, e.SRN)
+		}
+	}
+	return nil
+}
+
+// webhookSink posts the events of a run as a JSON array
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookSink(url string) *webhookSink {
+	return &webhookSink{url: url, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (ws *webhookSink) Emit(events []WatchEvent) error {
+	buf, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	resp, err := ws.client.Post(ws.url, "application/json", bytes.NewBuffer(buf))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// eventLog keeps the most recent events in memory,
+// so clients can poll /events?since=<id> for what they have not seen
+type eventLog struct {
+	mu     sync.RWMutex
+	max    int
+	events []WatchEvent
+}
+
+func newEventLog(max int) *eventLog {
+	return &eventLog{max: max}
+}
+
+func (el *eventLog) Emit(events []WatchEvent) error {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	el.events = append(el.events, events...)
+	if len(el.events) > el.max {
+		el.events = append([]WatchEvent(nil), el.events[len(el.events)-el.max:]...)
+	}
+	return nil
+}
+
+// returns the events with an ID greater than since
+func (el *eventLog) Since(since int64) []WatchEvent {
+	el.mu.RLock()
+	defer el.mu.RUnlock()
+	i := sort.Search(len(el.events), func(i int) bool { return el.events[i].ID > since })
+	return append([]WatchEvent{}, el.events[i:]...)
+}
+
+/*
+	Events handler returns the recent watcher events as JSON,
+	"since" skips the events the client has already seen
+*/
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+
+	var since int64
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		if since, err = strconv.ParseInt(s, 10, 64); err != nil {
+			http.Error(w, "since must be an event id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, recentEvents.Since(since))
+}
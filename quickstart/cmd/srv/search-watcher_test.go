@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// a search listing two versions of a record reports the highest, in whatever order they come
+func TestAddSRNKeepsHighestVersion(t *testing.T) {
+
+	runs := [][]string{
+		{"srn:file/las2:831:2", "srn:file/las2:831:10", "srn:master-data/Well:8438:1"},
+		{"srn:file/las2:831:10", "srn:file/las2:831:2", "srn:master-data/Well:8438:1", ""},
+		{"srn:master-data/Well:8438:1", "srn:file/las2:831:2", "srn:file/las2:831:10"},
+	}
+
+	var previous map[string]string
+	for i, run := range runs {
+		current := map[string]string{}
+		for _, srn := range run {
+			addSRN(current, srn)
+		}
+		if current["srn:file/las2:831"] != "srn:file/las2:831:10" || len(current) != 2 {
+			t.Fatalf("run %d kept %v", i, current)
+		}
+		if previous != nil {
+			if events := diffSRNs("logs", previous, current); len(events) != 0 {
+				t.Errorf("run %d reported %+v", i, events)
+			}
+		}
+		previous = current
+	}
+
+	current := map[string]string{}
+	for _, srn := range []string{"srn:file/las2:831:11", "srn:file/las2:831:10"} {
+		addSRN(current, srn)
+	}
+	events := diffSRNs("logs", previous, current)
+	var got []string
+	for _, e := range events {
+		got = append(got, e.Type+" "+e.SRN+" "+e.PreviousSRN)
+	}
+	want := "new-version srn:file/las2:831:11 srn:file/las2:831:10|removed srn:master-data/Well:8438:1 "
+	if strings.Join(got, "|") != want {
+		t.Errorf("events %q, want %q", strings.Join(got, "|"), want)
+	}
+}
+
+func TestSplitSRNVersion(t *testing.T) {
+	for _, c := range []struct{ srn, base, version string }{
+		{"srn:file/csv:6dd13750df8611e9b5df4fa704076d5c:1", "srn:file/csv:6dd13750df8611e9b5df4fa704076d5c", "1"},
+		{"srn:file/csv:6dd13750df8611e9b5df4fa704076d5c:", "srn:file/csv:6dd13750df8611e9b5df4fa704076d5c", ""},
+		{"8438", "8438", ""},
+	} {
+		if base, version := splitSRNVersion(c.srn); base != c.base || version != c.version {
+			t.Errorf("%s split into %q and %q", c.srn, base, version)
+		}
+	}
+}
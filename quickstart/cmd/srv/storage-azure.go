@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"golang.org/x/net/context"
+)
+
+// block size and number of blocks in flight when a blob is uploaded
+const (
+	azureUploadBlockSize = 4 << 20
+	azureUploadBuffers   = 4
+)
+
+// azureBackend reads and writes blobs in Azure Blob storage (or Azurite) with a SAS token
+type azureBackend struct{}
+
+func (azureBackend) Name() string { return backendAzure }
+
+/*
+	This function constructs the pre-signed blob URL from the location,
+	e.g. https://<account>.blob.core.windows.net/<container>/<key>?<SAS>,
+	or takes the signed URL as it is (File service upload locations)
+*/
+func (azureBackend) blobURL(loc FileLocation) (azblob.BlobURL, error) {
+
+	signedURL := loc.signedURL()
+	if loc.TemporaryCredentials.SAS != "" || signedURL == "" {
+		blobURL, err := loc.objectURL()
+		if err != nil {
+			return azblob.BlobURL{}, err
+		}
+		if loc.TemporaryCredentials.SAS == "" {
+			return azblob.BlobURL{}, errors.New("no SAS token in Delivery API response")
+		}
+		signedURL = blobURL + "?" + loc.TemporaryCredentials.SAS
+	}
+
+	// When someone receives the URL, they access the SAS-protected resource with code like this:
+	u, err := url.Parse(signedURL)
+	if err != nil {
+		return azblob.BlobURL{}, err
+	}
+
+	// Create an BlobURL object that wraps the blob URL (and its SAS) and a pipeline.
+	// When using a SAS URLs, anonymous credentials are required.
+	return azblob.NewBlobURL(*u, azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{})), nil
+}
+
+/*
+	Function downloads the range of the blob, Azure has no suffix ranges
+	so the blob size is read first when the last bytes are asked for
+*/
+func (b azureBackend) Open(ctx context.Context, loc FileLocation, rng byteRange) (*storageObject, error) {
+
+	blobURL, err := b.blobURL(loc)
+	if err != nil {
+		return nil, err
+	}
+
+	offset, count := rng.Offset, rng.Count
+	if rng.Suffix > 0 {
+		props, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{})
+		if err != nil {
+			return nil, err
+		}
+		size := props.ContentLength()
+		if offset, count = size-rng.Suffix, rng.Suffix; offset < 0 {
+			offset, count = 0, size
+		}
+		if size == 0 {
+			return nil, errRangeNotSatisfiable
+		}
+	}
+
+	// a single GET for the range, the body is read as we write it out
+	resp, err := blobURL.Download(ctx, offset, count, azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		if storageErrorCode(err) == http.StatusRequestedRangeNotSatisfiable {
+			return nil, errRangeNotSatisfiable
+		}
+		return nil, err
+	}
+
+	// the retry reader resumes from the last byte read if the connection drops
+	body := resp.Body(azblob.RetryReaderOptions{MaxRetryRequests: 3})
+
+	// the blob MD5 comes with every range, Content-MD5 is the blob MD5 for a full read
+	md5 := resp.BlobContentMD5()
+	if len(md5) == 0 && resp.StatusCode() == http.StatusOK {
+		md5 = resp.ContentMD5()
+	}
+
+	if resp.StatusCode() != http.StatusPartialContent {
+		obj := &storageObject{Body: body, Size: resp.ContentLength(), TotalSize: resp.ContentLength(), MD5: md5}
+		if offset == 0 && count == azblob.CountToEnd {
+			return obj, nil
+		}
+		// an emulator that ignored x-ms-range sent the whole blob
+		return sliceObject(obj, byteRange{Offset: offset, Count: count})
+	}
+
+	obj := &storageObject{Body: body, Size: resp.ContentLength(), Offset: offset, MD5: md5}
+	if _, _, obj.TotalSize, err = parseContentRange(resp.ContentRange()); err != nil {
+		body.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+// uploads the blob in blocks, a few of them at a time
+func (b azureBackend) Put(ctx context.Context, loc FileLocation, body io.Reader, size int64, contentType string) error {
+	blobURL, err := b.blobURL(loc)
+	if err != nil {
+		return err
+	}
+	_, err = azblob.UploadStreamToBlockBlob(ctx, body, blobURL.ToBlockBlobURL(), azblob.UploadStreamToBlockBlobOptions{
+		BufferSize:      azureUploadBlockSize,
+		MaxBuffers:      azureUploadBuffers,
+		BlobHTTPHeaders: azblob.BlobHTTPHeaders{ContentType: contentType},
+	})
+	return err
+}
+
+func (b azureBackend) URL(loc FileLocation) (string, error) {
+	blobURL, err := b.blobURL(loc)
+	if err != nil {
+		return "", err
+	}
+	u := blobURL.URL()
+	return u.String(), nil
+}
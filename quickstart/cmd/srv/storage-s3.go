@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// region assumed when the Delivery API response does not name one
+const defaultS3Region = "us-east-1"
+
+// payload hash sent with signed GETs, there is no body to hash
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// how long presigned URLs handed to clients work, at most until the credentials expire
+const presignExpiry = 15 * time.Minute
+
+// s3Backend reads objects from AWS S3 (or MinIO) either through a presigned URL
+// or with the temporary session credentials from the Delivery API response
+type s3Backend struct {
+	client *http.Client
+}
+
+func (s3Backend) Name() string { return backendS3 }
+
+func (b s3Backend) Open(ctx context.Context, loc FileLocation, rng byteRange) (*storageObject, error) {
+
+	req, err := b.newRequest(http.MethodGet, loc, rng)
+	if err != nil {
+		return nil, err
+	}
+	return getObject(b.client, b.Name(), req.WithContext(ctx), rng)
+}
+
+func (b s3Backend) Put(ctx context.Context, loc FileLocation, body io.Reader, size int64, contentType string) error {
+	req, err := b.newRequest(http.MethodPut, loc, byteRange{})
+	if err != nil {
+		return err
+	}
+	return putObject(b.client, b.Name(), req.WithContext(ctx), body, size, contentType)
+}
+
+/*
+	Function returns the presigned URL from the Delivery API response, or
+	presigns the object URL with the temporary session credentials
+*/
+func (b s3Backend) URL(loc FileLocation) (string, error) {
+
+	if signed := loc.signedURL(); signed != "" {
+		return signed, nil
+	}
+	req, err := b.newRequest(http.MethodGet, loc, byteRange{})
+	if err != nil {
+		return "", err
+	}
+	region := loc.Region
+	if region == "" {
+		region = defaultS3Region
+	}
+
+	now := time.Now().UTC()
+	expires := presignExpiry
+	if t, err := time.Parse(time.RFC3339, loc.TemporaryCredentials.Expiration); err == nil && t.Sub(now) < expires {
+		expires = t.Sub(now)
+	}
+	if expires <= 0 {
+		return "", errors.New("S3 credentials in Delivery API response have expired")
+	}
+
+	u := *req.URL
+	u.RawQuery = ""
+	return presignV4(&u, loc.TemporaryCredentials, region, "s3", now, expires), nil
+}
+
+/*
+	Function builds the GET or PUT for the object: a presigned URL is used as
+	it is, otherwise the request to the end point (path-style, as MinIO expects
+	it) or to the bucket host on AWS is signed with Signature Version 4
+*/
+func (b s3Backend) newRequest(method string, loc FileLocation, rng byteRange) (*http.Request, error) {
+
+	if signed := loc.signedURL(); signed != "" {
+		req, err := http.NewRequest(method, signed, nil)
+		if err == nil && rng.Header() != "" {
+			req.Header.Set("Range", rng.Header())
+		}
+		return req, err
+	}
+
+	creds := loc.TemporaryCredentials
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, errors.New("no S3 credentials or presigned URL in Delivery API response")
+	}
+
+	region := loc.Region
+	if region == "" {
+		region = defaultS3Region
+	}
+
+	var objectURL string
+	if loc.EndPoint != "" {
+		var err error
+		if objectURL, err = loc.objectURL(); err != nil {
+			return nil, err
+		}
+	} else {
+		if loc.Bucket == "" || loc.Key == "" {
+			return nil, errors.New("incomplete file location in Delivery API response")
+		}
+		objectURL = "https://" + loc.Bucket + ".s3." + region + ".amazonaws.com/" + strings.TrimPrefix(loc.Key, "/")
+	}
+
+	req, err := http.NewRequest(method, objectURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if header := rng.Header(); header != "" {
+		req.Header.Set("Range", header)
+	}
+	signV4(req, creds, region, "s3", time.Now().UTC())
+
+	return req, nil
+}
+
+/*
+	Function signs the request with AWS Signature Version 4 in the
+	Authorization header, host, range and x-amz-* headers are signed and
+	the payload is left unsigned unless X-Amz-Content-Sha256 is already set
+*/
+func signV4(req *http.Request, creds TemporaryCredentials, region, service string, now time.Time) {
+
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = unsignedPayload
+	}
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	// send the path exactly as it is signed
+	req.URL.RawPath = awsURIEncode(req.URL.Path, false)
+
+	// canonical headers are lower case, sorted by name
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") || lower == "range" {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsURIEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+/*
+	Function signs a GET for the URL with Signature Version 4 in the query
+	string, only the host header is signed so any client can use the URL
+*/
+func presignV4(u *url.URL, creds TemporaryCredentials, region, service string, now time.Time, expires time.Duration) string {
+
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", creds.AccessKeyID+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if creds.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		awsURIEncode(u.Path, false),
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+
+	u.RawPath = awsURIEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query) + "&X-Amz-Signature=" + hex.EncodeToString(hmacSHA256(key, stringToSign))
+	return u.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// encodes query parameters sorted by encoded name, then value, the way Signature Version 4 expects
+func canonicalQuery(values url.Values) string {
+	var pairs [][2]string
+	for name, vs := range values {
+		for _, v := range vs {
+			pairs = append(pairs, [2]string{awsURIEncode(name, true), awsURIEncode(v, true)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	encoded := make([]string, len(pairs))
+	for i, p := range pairs {
+		encoded[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(encoded, "&")
+}
+
+// percent-encodes everything but unreserved characters, slashes are kept in paths
+func awsURIEncode(s string, encodeSlash bool) string {
+	if s == "" && !encodeSlash {
+		return "/"
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return b.String()
+}
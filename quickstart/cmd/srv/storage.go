@@ -0,0 +1,470 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// storage backends a file location can be served from
+const (
+	backendAzure = "azure"
+	backendS3    = "s3"
+	backendGCS   = "gcs"
+	backendHTTPS = "https"
+)
+
+// storageObject is a file (or a range of it) opened for reading in a storage backend
+type storageObject struct {
+	Body io.ReadCloser
+
+	// Size is the length of Body, Offset where it starts in the file
+	Size   int64
+	Offset int64
+
+	// TotalSize is the length of the whole file, -1 when storage did not say
+	TotalSize int64
+
+	// MD5 of the whole file as storage keeps it, nil when it does not
+	MD5 []byte
+}
+
+// byteRange selects part of a file, the zero value selects all of it
+type byteRange struct {
+	Offset int64
+	Count  int64 // bytes from Offset, 0 reads to the end
+	Suffix int64 // last bytes of the file, used instead of Offset and Count
+}
+
+// reports whether the range selects the whole file
+func (r byteRange) IsZero() bool {
+	return r == byteRange{}
+}
+
+// returns the range as a Range header value, empty for the whole file
+func (r byteRange) Header() string {
+	switch {
+	case r.Suffix > 0:
+		return "bytes=-" + strconv.FormatInt(r.Suffix, 10)
+	case r.Count > 0:
+		return "bytes=" + strconv.FormatInt(r.Offset, 10) + "-" + strconv.FormatInt(r.Offset+r.Count-1, 10)
+	case r.Offset > 0:
+		return "bytes=" + strconv.FormatInt(r.Offset, 10) + "-"
+	}
+	return ""
+}
+
+// errRangeNotSatisfiable is returned when the range starts past the end of the file
+var errRangeNotSatisfiable = errors.New("requested range not satisfiable")
+
+// storageBackend reads and writes files in the storage a FileLocation points to
+type storageBackend interface {
+	// Name identifies the backend in logs and errors
+	Name() string
+
+	// Open starts reading the range of the file, the read is cancelled when ctx is done
+	Open(ctx context.Context, loc FileLocation, rng byteRange) (*storageObject, error)
+
+	// URL returns a URL clients can download the file from without credentials of their own
+	URL(loc FileLocation) (string, error)
+
+	// Put writes size bytes of body as the file, replacing what is there
+	Put(ctx context.Context, loc FileLocation, body io.Reader, size int64, contentType string) error
+}
+
+// errNoShareableURL is returned by backends that can only read the file with a token
+var errNoShareableURL = errors.New("storage location has no URL that can be shared")
+
+// client used by the backends that talk plain HTTP (S3, GCS and HTTPS)
+var storageHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: 60 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   16,
+	},
+}
+
+// backends by name, the S3 and GCS ones can point to local stand-ins
+// such as MinIO or an httptest server through the location end point
+var storageBackends = map[string]storageBackend{
+	backendAzure: azureBackend{},
+	backendS3:    s3Backend{client: storageHTTPClient},
+	backendGCS:   gcsBackend{client: storageHTTPClient},
+	backendHTTPS: httpsBackend{client: storageHTTPClient},
+}
+
+/*
+	Function picks the storage backend for a file location from what the
+	Delivery API response carries: an explicit provider, the kind of temporary
+	credentials, a signed URL or finally the host of the end point
+*/
+func resolveBackend(loc FileLocation) (storageBackend, error) {
+
+	if loc.Provider != "" {
+		if backend, ok := storageBackends[strings.ToLower(loc.Provider)]; ok {
+			return backend, nil
+		}
+		return nil, fmt.Errorf("unsupported storage provider %q", loc.Provider)
+	}
+
+	creds := loc.TemporaryCredentials
+	switch {
+	case creds.SAS != "":
+		return storageBackends[backendAzure], nil
+	case creds.AccessKeyID != "":
+		return storageBackends[backendS3], nil
+	}
+
+	host := loc.EndPoint
+	if signed := loc.signedURL(); signed != "" {
+		host = signed
+		if strings.Contains(signed, "X-Amz-Signature=") {
+			return storageBackends[backendS3], nil
+		}
+		// a SAS carries the service version and signature, Azurite runs on any host
+		if u, err := url.Parse(signed); err == nil && u.Query().Get("sv") != "" && u.Query().Get("sig") != "" {
+			return storageBackends[backendAzure], nil
+		}
+	}
+	if u, err := url.Parse(host); err == nil {
+		host = strings.ToLower(u.Host)
+	}
+
+	switch {
+	case strings.HasSuffix(host, ".blob.core.windows.net"):
+		return storageBackends[backendAzure], nil
+	case strings.HasSuffix(host, "amazonaws.com"):
+		return storageBackends[backendS3], nil
+	case host == "storage.googleapis.com" || strings.HasSuffix(host, ".storage.googleapis.com"):
+		return storageBackends[backendGCS], nil
+	}
+
+	return storageBackends[backendHTTPS], nil
+}
+
+// opens the range of the file at the location with the backend picked for it
+func openFile(ctx context.Context, loc FileLocation, rng byteRange) (*storageObject, storageBackend, error) {
+	backend, err := resolveBackend(loc)
+	if err != nil {
+		return nil, nil, err
+	}
+	obj, err := backend.Open(ctx, loc, rng)
+	return obj, backend, err
+}
+
+// returns the signed URL from the location or its credentials
+func (l FileLocation) signedURL() string {
+	if l.SignedURL != "" {
+		return l.SignedURL
+	}
+	return l.TemporaryCredentials.SignedURL
+}
+
+// joins end point, bucket and key into a path-style object URL
+func (l FileLocation) objectURL() (string, error) {
+	if l.EndPoint == "" || l.Key == "" {
+		return "", errors.New("incomplete file location in Delivery API response")
+	}
+	endPoint := strings.TrimSuffix(l.EndPoint, "/")
+	if l.Bucket == "" {
+		return endPoint + "/" + strings.TrimPrefix(l.Key, "/"), nil
+	}
+	return endPoint + "/" + l.Bucket + "/" + strings.TrimPrefix(l.Key, "/"), nil
+}
+
+/*
+	Function reads when a storage URL stops working from its query string:
+	se of an Azure SAS, X-Amz-Date and X-Amz-Expires of an S3 presigned URL,
+	X-Goog-Date and X-Goog-Expires of a GCS signed URL, or else from the
+	expiration of the temporary credentials; nil when nothing tells it
+*/
+func urlExpiry(rawURL string, creds TemporaryCredentials) *time.Time {
+
+	var expires time.Time
+	if u, err := url.Parse(rawURL); err == nil {
+		q := u.Query()
+		if se := q.Get("se"); se != "" {
+			if t, err := time.Parse(time.RFC3339, se); err == nil {
+				expires = t
+			} else if t, err := time.Parse("2006-01-02", se); err == nil {
+				expires = t
+			}
+		}
+		for _, prefix := range []string{"X-Amz-", "X-Goog-"} {
+			signed, err := time.Parse("20060102T150405Z", q.Get(prefix+"Date"))
+			seconds, err2 := strconv.Atoi(q.Get(prefix + "Expires"))
+			if err == nil && err2 == nil {
+				expires = signed.Add(time.Duration(seconds) * time.Second)
+			}
+		}
+	}
+	if expires.IsZero() && creds.Expiration != "" {
+		if t, err := time.Parse(time.RFC3339, creds.Expiration); err == nil {
+			expires = t
+		}
+	}
+	if expires.IsZero() {
+		return nil
+	}
+	expires = expires.UTC()
+	return &expires
+}
+
+// storageStatusError is returned when storage answers with an unexpected status
+type storageStatusError struct {
+	Backend    string
+	StatusCode int
+	Status     string
+}
+
+func (e *storageStatusError) Error() string {
+	return e.Backend + " storage returned " + e.Status
+}
+
+/*
+	Function sends a GET built by the backend (with the Range header already
+	set for rng) and returns the body as an opened object; a server that
+	ignores the range is handled by skipping and limiting the full body
+*/
+func getObject(client *http.Client, backend string, req *http.Request, rng byteRange) (*storageObject, error) {
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, end, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		return &storageObject{Body: resp.Body, Size: end - start + 1, Offset: start, TotalSize: total,
+			MD5: storageMD5(resp.Header, true)}, nil
+
+	case http.StatusOK:
+		obj := &storageObject{Body: resp.Body, Size: resp.ContentLength, TotalSize: resp.ContentLength,
+			MD5: storageMD5(resp.Header, false)}
+		if rng.IsZero() {
+			return obj, nil
+		}
+		return sliceObject(obj, rng)
+
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		return nil, errRangeNotSatisfiable
+	}
+
+	resp.Body.Close()
+	return nil, &storageStatusError{Backend: backend, StatusCode: resp.StatusCode, Status: resp.Status}
+}
+
+/*
+	Function sends a PUT built by the backend with the file as its body,
+	the size is set up front as S3 and signed URLs refuse chunked uploads
+*/
+func putObject(client *http.Client, backend string, req *http.Request, body io.Reader, size int64, contentType string) error {
+
+	req.Body = ioutil.NopCloser(body)
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	}
+	return &storageStatusError{Backend: backend, StatusCode: resp.StatusCode, Status: resp.Status}
+}
+
+// cuts the range out of a full file body for servers without range support
+func sliceObject(obj *storageObject, rng byteRange) (*storageObject, error) {
+
+	if obj.TotalSize < 0 {
+		obj.Body.Close()
+		return nil, errors.New("storage ignored the range and sent no length")
+	}
+
+	start, end := rng.Offset, obj.TotalSize-1
+	if rng.Suffix > 0 {
+		start = obj.TotalSize - rng.Suffix
+		if start < 0 {
+			start = 0
+		}
+	} else if rng.Count > 0 && rng.Offset+rng.Count-1 < end {
+		end = rng.Offset + rng.Count - 1
+	}
+	if start >= obj.TotalSize {
+		obj.Body.Close()
+		return nil, errRangeNotSatisfiable
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, obj.Body, start); err != nil {
+		obj.Body.Close()
+		return nil, err
+	}
+	body := struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(obj.Body, end-start+1), obj.Body}
+
+	return &storageObject{Body: body, Size: end - start + 1, Offset: start, TotalSize: obj.TotalSize, MD5: obj.MD5}, nil
+}
+
+// parses a Content-Range header such as "bytes 0-9/1234", total is -1 for "*"
+func parseContentRange(header string) (start, end, total int64, err error) {
+
+	invalid := fmt.Errorf("invalid Content-Range %q from storage", header)
+
+	spec := strings.TrimPrefix(header, "bytes ")
+	slash := strings.Index(spec, "/")
+	dash := strings.Index(spec, "-")
+	if spec == header || slash < 0 || dash < 0 || dash > slash {
+		return 0, 0, 0, invalid
+	}
+
+	if start, err = strconv.ParseInt(spec[:dash], 10, 64); err != nil {
+		return 0, 0, 0, invalid
+	}
+	if end, err = strconv.ParseInt(spec[dash+1:slash], 10, 64); err != nil || end < start {
+		return 0, 0, 0, invalid
+	}
+	total = -1
+	if spec[slash+1:] != "*" {
+		if total, err = strconv.ParseInt(spec[slash+1:], 10, 64); err != nil {
+			return 0, 0, 0, invalid
+		}
+	}
+
+	return start, end, total, nil
+}
+
+// httpsBackend reads files from a signed URL or a public HTTPS end point
+type httpsBackend struct {
+	client *http.Client
+}
+
+func (httpsBackend) Name() string { return backendHTTPS }
+
+func (b httpsBackend) Open(ctx context.Context, loc FileLocation, rng byteRange) (*storageObject, error) {
+
+	fileURL := loc.signedURL()
+	if fileURL == "" {
+		var err error
+		if fileURL, err = loc.objectURL(); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if header := rng.Header(); header != "" {
+		req.Header.Set("Range", header)
+	}
+	return getObject(b.client, b.Name(), req.WithContext(ctx), rng)
+}
+
+func (b httpsBackend) URL(loc FileLocation) (string, error) {
+	if signed := loc.signedURL(); signed != "" {
+		return signed, nil
+	}
+	return loc.objectURL()
+}
+
+func (b httpsBackend) Put(ctx context.Context, loc FileLocation, body io.Reader, size int64, contentType string) error {
+	fileURL, err := b.URL(loc)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, fileURL, nil)
+	if err != nil {
+		return err
+	}
+	return putObject(b.client, b.Name(), req.WithContext(ctx), body, size, contentType)
+}
+
+// default end point of Google Cloud Storage XML API
+const gcsEndPoint = "https://storage.googleapis.com"
+
+// gcsBackend reads files from Google Cloud Storage through a V4 signed URL,
+// or with an OAuth access token when the credentials carry one
+type gcsBackend struct {
+	client *http.Client
+}
+
+func (gcsBackend) Name() string { return backendGCS }
+
+func (b gcsBackend) Open(ctx context.Context, loc FileLocation, rng byteRange) (*storageObject, error) {
+
+	req, err := b.newRequest(http.MethodGet, loc)
+	if err != nil {
+		return nil, err
+	}
+	if header := rng.Header(); header != "" {
+		req.Header.Set("Range", header)
+	}
+	return getObject(b.client, b.Name(), req.WithContext(ctx), rng)
+}
+
+func (b gcsBackend) Put(ctx context.Context, loc FileLocation, body io.Reader, size int64, contentType string) error {
+	req, err := b.newRequest(http.MethodPut, loc)
+	if err != nil {
+		return err
+	}
+	return putObject(b.client, b.Name(), req.WithContext(ctx), body, size, contentType)
+}
+
+// builds a request to the signed URL, or to the object URL with the access token
+func (b gcsBackend) newRequest(method string, loc FileLocation) (*http.Request, error) {
+
+	fileURL := loc.signedURL()
+	if fileURL == "" {
+		if loc.EndPoint == "" {
+			loc.EndPoint = gcsEndPoint
+		}
+		var err error
+		if fileURL, err = loc.objectURL(); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(method, fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := loc.TemporaryCredentials.AccessToken; token != "" && loc.signedURL() == "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// only a signed URL can be shared, an access token belongs to this server
+func (b gcsBackend) URL(loc FileLocation) (string, error) {
+	if signed := loc.signedURL(); signed != "" {
+		return signed, nil
+	}
+	return "", errNoShareableURL
+}
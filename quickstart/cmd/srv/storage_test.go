@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// standIn is a local storage server: objects by path, served with range support
+type standIn struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	objects     map[string][]byte
+	requests    []*http.Request
+	ignoreRange bool // answer ranges with the whole object, like some plain HTTP servers
+}
+
+func newStandIn(objects map[string][]byte) *standIn {
+	s := &standIn{objects: objects}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *standIn) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r)
+
+	switch r.Method {
+	case http.MethodGet:
+		data, ok := s.objects[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if s.ignoreRange {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	case http.MethodPut:
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil || int64(len(data)) != r.ContentLength {
+			http.Error(w, "short body", http.StatusBadRequest)
+			return
+		}
+		s.objects[r.URL.Path] = data
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// returns the last request the stand-in got
+func (s *standIn) last() *http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[len(s.requests)-1]
+}
+
+// reads a whole object or a range of it through the backend
+func readObject(t *testing.T, backend storageBackend, loc FileLocation, rng byteRange) (*storageObject, string) {
+	t.Helper()
+	obj, err := backend.Open(context.Background(), loc, rng)
+	if err != nil {
+		t.Fatalf("%s open %+v: %s", backend.Name(), rng, err)
+	}
+	defer obj.Body.Close()
+	data, err := ioutil.ReadAll(obj.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj, string(data)
+}
+
+func TestResolveBackend(t *testing.T) {
+
+	tests := []struct {
+		name string
+		loc  FileLocation
+		want string
+	}{
+		{"explicit provider", FileLocation{Provider: "GCS", EndPoint: "https://example.blob.core.windows.net"}, backendGCS},
+		{"SAS token", FileLocation{EndPoint: "http://127.0.0.1:10000/devstoreaccount1", TemporaryCredentials: TemporaryCredentials{SAS: "sv=2019-02-02&sig=x"}}, backendAzure},
+		{"session credentials", FileLocation{EndPoint: "http://minio:9000", TemporaryCredentials: TemporaryCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}}, backendS3},
+		{"S3 presigned URL", FileLocation{SignedURL: "http://minio:9000/b/k?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Signature=abc"}, backendS3},
+		{"SAS signed URL on Azurite", FileLocation{TemporaryCredentials: TemporaryCredentials{SignedURL: "http://127.0.0.1:10000/devstoreaccount1/c/k?sv=2019-02-02&sig=abc"}}, backendAzure},
+		{"GCS signed URL", FileLocation{SignedURL: "https://storage.googleapis.com/b/k?X-Goog-Signature=abc"}, backendGCS},
+		{"Azure end point", FileLocation{EndPoint: "https://tno.blob.core.windows.net/", Key: "k"}, backendAzure},
+		{"AWS end point", FileLocation{EndPoint: "https://tno.s3.eu-west-1.amazonaws.com", Key: "k"}, backendS3},
+		{"GCS bucket host", FileLocation{EndPoint: "https://tno.storage.googleapis.com", Key: "k"}, backendGCS},
+		{"anything else", FileLocation{EndPoint: "https://files.example.com", Key: "k"}, backendHTTPS},
+		{"signed URL elsewhere", FileLocation{SignedURL: "https://files.example.com/k?token=abc"}, backendHTTPS},
+	}
+	for _, tt := range tests {
+		backend, err := resolveBackend(tt.loc)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if backend.Name() != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, backend.Name(), tt.want)
+		}
+	}
+
+	if _, err := resolveBackend(FileLocation{Provider: "ftp"}); err == nil {
+		t.Error("unknown provider resolved to a backend")
+	}
+}
+
+func TestHTTPSBackend(t *testing.T) {
+
+	srv := newStandIn(map[string][]byte{"/files/8438.csv": []byte("MD,INC,AZI\n0,0,0\n")})
+	defer srv.Close()
+	backend := httpsBackend{client: srv.Client()}
+	loc := FileLocation{EndPoint: srv.URL + "/", Key: "/files/8438.csv"}
+
+	obj, data := readObject(t, backend, loc, byteRange{})
+	if data != "MD,INC,AZI\n0,0,0\n" || obj.TotalSize != 17 {
+		t.Errorf("whole file: %q, total %d", data, obj.TotalSize)
+	}
+
+	obj, data = readObject(t, backend, loc, byteRange{Offset: 3, Count: 3})
+	if data != "INC" || obj.Offset != 3 || obj.Size != 3 || obj.TotalSize != 17 {
+		t.Errorf("range: %q at %d size %d of %d", data, obj.Offset, obj.Size, obj.TotalSize)
+	}
+	if got := srv.last().Header.Get("Range"); got != "bytes=3-5" {
+		t.Errorf("Range header %q", got)
+	}
+
+	// a server that ignores ranges sends the whole file, the backend cuts the range out
+	srv.ignoreRange = true
+	obj, data = readObject(t, backend, loc, byteRange{Suffix: 6})
+	if data != "0,0,0\n" || obj.Offset != 11 {
+		t.Errorf("suffix range from a server without ranges: %q at %d", data, obj.Offset)
+	}
+	srv.ignoreRange = false
+
+	if _, err := backend.Open(context.Background(), loc, byteRange{Offset: 100}); err != errRangeNotSatisfiable {
+		t.Errorf("range past the end: %v", err)
+	}
+	_, err := backend.Open(context.Background(), FileLocation{EndPoint: srv.URL, Key: "missing"}, byteRange{})
+	if status, ok := err.(*storageStatusError); !ok || status.StatusCode != http.StatusNotFound {
+		t.Errorf("missing file: %v", err)
+	}
+
+	// a signed URL is used as it is, for reading, sharing and writing
+	signed := FileLocation{SignedURL: srv.URL + "/upload/a05.las?token=abc"}
+	if u, err := backend.URL(signed); err != nil || u != signed.SignedURL {
+		t.Errorf("URL %q, %v", u, err)
+	}
+	if err := backend.Put(context.Background(), signed, strings.NewReader("~V\n"), 3, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if string(srv.objects["/upload/a05.las"]) != "~V\n" || srv.last().URL.RawQuery != "token=abc" {
+		t.Errorf("put stored %q with query %q", srv.objects["/upload/a05.las"], srv.last().URL.RawQuery)
+	}
+	if got := srv.last().Header.Get("Content-Type"); got != "text/plain" {
+		t.Errorf("put content type %q", got)
+	}
+}
+
+func TestGCSBackend(t *testing.T) {
+
+	srv := newStandIn(map[string][]byte{"/tno/logs/a05.las": []byte("~V\nVERS. 2.0\n")})
+	defer srv.Close()
+	backend := gcsBackend{client: srv.Client()}
+
+	// an access token goes in the Authorization header of requests to the end point
+	token := FileLocation{EndPoint: srv.URL, Bucket: "tno", Key: "logs/a05.las",
+		TemporaryCredentials: TemporaryCredentials{AccessToken: "ya29.token"}}
+	_, data := readObject(t, backend, token, byteRange{Offset: 3})
+	if data != "VERS. 2.0\n" {
+		t.Errorf("range with a token: %q", data)
+	}
+	if got := srv.last().Header.Get("Authorization"); got != "Bearer ya29.token" {
+		t.Errorf("Authorization %q", got)
+	}
+	if _, err := backend.URL(token); err != errNoShareableURL {
+		t.Errorf("URL of a token location: %v", err)
+	}
+	if err := backend.Put(context.Background(), token, strings.NewReader("new"), 3, ""); err != nil {
+		t.Fatal(err)
+	}
+	if string(srv.objects["/tno/logs/a05.las"]) != "new" {
+		t.Errorf("put with a token stored %q", srv.objects["/tno/logs/a05.las"])
+	}
+
+	// a signed URL is read without the token
+	signed := FileLocation{SignedURL: srv.URL + "/tno/logs/a05.las?X-Goog-Signature=abc",
+		TemporaryCredentials: TemporaryCredentials{AccessToken: "ya29.token"}}
+	if _, data := readObject(t, backend, signed, byteRange{}); data != "new" {
+		t.Errorf("signed URL: %q", data)
+	}
+	if got := srv.last().Header.Get("Authorization"); got != "" {
+		t.Errorf("signed URL sent Authorization %q", got)
+	}
+	if u, err := backend.URL(signed); err != nil || u != signed.SignedURL {
+		t.Errorf("URL %q, %v", u, err)
+	}
+}
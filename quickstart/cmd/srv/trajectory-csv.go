@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// quantities a trajectory column can hold
+const (
+	quantityMD          = "md"
+	quantityInclination = "inclination"
+	quantityAzimuth     = "azimuth"
+	quantityTVD         = "tvd"
+	quantityX           = "x"
+	quantityY           = "y"
+)
+
+// rows with errors reported at most, the rest are only counted
+const maxTrajectoryErrors = 100
+
+// Trajectory is a directional survey read from a CSV file
+type Trajectory struct {
+	// DepthUnit is the unit of MD and TVD, XYUnit the one of X and Y;
+	// angles are always in degrees, whatever unit the file uses
+	DepthUnit string `json:"depthUnit"`
+	XYUnit    string `json:"xyUnit,omitempty"`
+
+	Columns  []TrajectoryColumn `json:"columns"`
+	Stations []SurveyStation    `json:"stations"`
+
+	// Errors lists the rows that were skipped, SkippedRows counts all of them
+	Errors      []TrajectoryRowError `json:"errors,omitempty"`
+	SkippedRows int                  `json:"skippedRows,omitempty"`
+}
+
+// TrajectoryColumn is a column of the file and what it was recognized as
+type TrajectoryColumn struct {
+	Name     string `json:"name"`               // header as in the file
+	Quantity string `json:"quantity,omitempty"` // empty for columns that are not used
+	Unit     string `json:"unit,omitempty"`     // from the header or a units row
+}
+
+// SurveyStation is a row of the survey, values the file does not have are nil
+type SurveyStation struct {
+	Line        int      `json:"line"`
+	MD          float64  `json:"md"`
+	Inclination *float64 `json:"inclination,omitempty"`
+	Azimuth     *float64 `json:"azimuth,omitempty"`
+	TVD         *float64 `json:"tvd,omitempty"`
+	X           *float64 `json:"x,omitempty"`
+	Y           *float64 `json:"y,omitempty"`
+}
+
+// TrajectoryRowError tells why a row of the file was skipped
+type TrajectoryRowError struct {
+	Line    int    `json:"line"`
+	Column  string `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e TrajectoryRowError) Error() string {
+	if e.Column != "" {
+		return fmt.Sprintf("line %d, %s: %s", e.Line, e.Column, e.Message)
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// header names of each quantity, compared without case, spaces and punctuation
+var trajectoryHeaders = map[string]string{
+	"md": quantityMD, "measureddepth": quantityMD, "depth": quantityMD, "dept": quantityMD, "mdepth": quantityMD,
+	"inc": quantityInclination, "incl": quantityInclination, "inclination": quantityInclination, "inclin": quantityInclination,
+	"azi": quantityAzimuth, "azim": quantityAzimuth, "azimuth": quantityAzimuth, "az": quantityAzimuth,
+	"azimuthtn": quantityAzimuth, "azimuthgn": quantityAzimuth, "azimuthtrue": quantityAzimuth, "azimuthgrid": quantityAzimuth,
+	"tvd": quantityTVD, "trueverticaldepth": quantityTVD,
+	"x": quantityX, "easting": quantityX, "east": quantityX, "xcoord": quantityX, "xcoordinate": quantityX,
+	"y": quantityY, "northing": quantityY, "north": quantityY, "ycoord": quantityY, "ycoordinate": quantityY,
+}
+
+// units of length and angle by the names files use, mapped to the name reported
+var (
+	lengthUnits = map[string]string{
+		"m": "m", "meter": "m", "meters": "m", "metre": "m", "metres": "m",
+		"ft": "ft", "feet": "ft", "foot": "ft", "f": "ft", "intlft": "ft",
+		"usft": "usft", "ftus": "usft", "sft": "usft",
+	}
+	angleUnits = map[string]string{
+		"deg": "deg", "degree": "deg", "degrees": "deg", "dega": "deg", "°": "deg",
+		"rad": "rad", "radian": "rad", "radians": "rad",
+	}
+)
+
+// metres in one unit of length
+var metresPer = map[string]float64{"m": 1, "ft": 0.3048, "usft": 1200.0 / 3937.0}
+
+// values that stand for a missing value
+var nullValues = map[string]bool{"": true, "-999.25": true, "-999": true, "-9999": true, "nan": true, "null": true, "n/a": true, "na": true}
+
+// normalizes a header or unit for lookups: lower case, no spaces or punctuation
+func normalizeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '_', '-', '.', '/', '(', ')', '[', ']':
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(s)))
+}
+
+/*
+	Function splits a header such as "MD (m)", "Inc[deg]", "TVD_ft" or
+	"Azimuth deg" into its name and unit, the unit is empty when the header
+	has none
+*/
+func splitHeaderUnit(header string) (name, unit string) {
+
+	header = strings.TrimSpace(header)
+	for _, brackets := range []string{"()", "[]", "{}"} {
+		if open := strings.LastIndexByte(header, brackets[0]); open > 0 && strings.HasSuffix(header, brackets[1:]) {
+			return strings.TrimSpace(header[:open]), strings.TrimSpace(header[open+1 : len(header)-1])
+		}
+	}
+	for _, sep := range []string{" ", "_"} {
+		if i := strings.LastIndex(header, sep); i > 0 && isUnit(header[i+1:]) {
+			if _, known := trajectoryHeaders[normalizeName(header[:i])]; known {
+				return strings.TrimSpace(header[:i]), header[i+1:]
+			}
+		}
+	}
+	return header, ""
+}
+
+// reports whether s names a unit of length or angle
+func isUnit(s string) bool {
+	_, length := lengthUnits[normalizeName(s)]
+	_, angle := angleUnits[normalizeName(s)]
+	return length || angle
+}
+
+/*
+	Function picks the delimiter of the header line: comma, semicolon or tab,
+	whichever it has most of; 0 means fields are separated by white space
+*/
+func detectDelimiter(line string) rune {
+	delimiter, most := rune(0), 0
+	for _, d := range []rune{',', ';', '\t'} {
+		if n := strings.Count(line, string(d)); n > most {
+			delimiter, most = d, n
+		}
+	}
+	return delimiter
+}
+
+// splits a line into trimmed fields, honouring quotes in delimited files
+func splitFields(line string, delimiter rune) ([]string, error) {
+	if delimiter == 0 {
+		return strings.Fields(line), nil
+	}
+	reader := csv.NewReader(strings.NewReader(line))
+	reader.Comma = delimiter
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+	fields, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	return fields, err
+}
+
+/*
+	Function joins the bracketed units of a white space separated header
+	to the name before them, so "MD (m)   INC (deg)" gives two fields
+*/
+func joinHeaderUnits(fields []string) []string {
+	joined := fields[:0:0]
+	for _, field := range fields {
+		if n := len(joined); n > 0 && len(field) > 2 && strings.ContainsAny(field[:1], "([{") && strings.ContainsAny(field[len(field)-1:], ")]}") {
+			joined[n-1] += " " + field
+			continue
+		}
+		joined = append(joined, field)
+	}
+	return joined
+}
+
+// reports whether a line is blank or a comment
+func skipLine(line string) bool {
+	line = strings.TrimSpace(line)
+	return line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//")
+}
+
+/*
+	Function maps the fields of a header line to quantities, nil when the
+	line is not the header: it needs a measured depth column and one of
+	inclination, azimuth or TVD, so a preamble line such as "Depth
+	reference: KB" is skipped
+*/
+func trajectoryColumns(fields []string) []TrajectoryColumn {
+
+	columns := make([]TrajectoryColumn, len(fields))
+	seen := map[string]bool{}
+	for i, field := range fields {
+		name, unit := splitHeaderUnit(field)
+		columns[i] = TrajectoryColumn{Name: field, Unit: unit}
+		// the first column of a quantity wins, e.g. MD before DEPTH
+		if quantity, ok := trajectoryHeaders[normalizeName(name)]; ok && !seen[quantity] {
+			columns[i].Quantity = quantity
+			seen[quantity] = true
+		}
+	}
+	if !seen[quantityMD] || !(seen[quantityInclination] || seen[quantityAzimuth] || seen[quantityTVD]) {
+		return nil
+	}
+	return columns
+}
+
+/*
+	Function parses a trajectory CSV: comment lines (# or //) and a preamble
+	before the header are skipped, the header is the first line naming a
+	measured depth and an inclination, azimuth or TVD column, columns are
+	recognized by name (MD, INC, AZI, TVD, X/Easting, Y/Northing and their
+	variants) with units taken from the header or a units row below it. Comma, semicolon, tab and space separated files
+	are read, a semicolon separated file may use decimal commas. Rows that
+	can not be read are skipped and reported with their line number; an error
+	is returned only when there is no header or no valid station
+*/
+func parseTrajectoryCSV(r io.Reader) (*Trajectory, error) {
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var (
+		traj      *Trajectory
+		delimiter rune
+		line      int
+		unitsRow  = true // the line after the header may carry the units
+		scales    map[string]float64
+	)
+
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff") // byte order mark
+		}
+		if skipLine(text) {
+			continue
+		}
+
+		if traj == nil {
+			delimiter = detectDelimiter(text)
+			fields, err := splitFields(text, delimiter)
+			if err != nil {
+				continue
+			}
+			if delimiter == 0 {
+				fields = joinHeaderUnits(fields)
+			}
+			if columns := trajectoryColumns(fields); columns != nil {
+				traj = &Trajectory{Columns: columns}
+			}
+			continue
+		}
+
+		fields, err := splitFields(text, delimiter)
+		if err != nil {
+			traj.skip(TrajectoryRowError{Line: line, Message: err.Error()})
+			continue
+		}
+
+		if unitsRow {
+			unitsRow = false
+			if traj.readUnitsRow(fields) {
+				continue
+			}
+		}
+		if scales == nil {
+			if scales, err = traj.unitScales(); err != nil {
+				return nil, err
+			}
+		}
+
+		station, rowErr := traj.parseRow(fields, line, delimiter, scales)
+		if rowErr != nil {
+			traj.skip(*rowErr)
+			continue
+		}
+		if n := len(traj.Stations); n > 0 && station.MD < traj.Stations[n-1].MD {
+			traj.skip(TrajectoryRowError{Line: line, Column: traj.columnName(quantityMD),
+				Message: fmt.Sprintf("measured depth %g is less than %g of line %d", station.MD, traj.Stations[n-1].MD, traj.Stations[n-1].Line)})
+			continue
+		}
+		traj.Stations = append(traj.Stations, station)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if traj == nil {
+		return nil, errors.New("no header with measured depth (MD, DEPTH or Measured Depth) and inclination, azimuth or TVD columns")
+	}
+	if len(traj.Stations) == 0 {
+		if len(traj.Errors) > 0 {
+			return traj, fmt.Errorf("no valid stations, first error at %s", traj.Errors[0])
+		}
+		return traj, errors.New("no stations after the header")
+	}
+	return traj, nil
+}
+
+// records a skipped row
+func (t *Trajectory) skip(err TrajectoryRowError) {
+	t.SkippedRows++
+	if len(t.Errors) < maxTrajectoryErrors {
+		t.Errors = append(t.Errors, err)
+	}
+}
+
+// returns the header of the column of a quantity
+func (t *Trajectory) columnName(quantity string) string {
+	for _, c := range t.Columns {
+		if c.Quantity == quantity {
+			return c.Name
+		}
+	}
+	return ""
+}
+
+/*
+	Function takes the row below the header as units when every field of a
+	recognized column is a unit or empty and at least one is a unit
+*/
+func (t *Trajectory) readUnitsRow(fields []string) bool {
+	units := 0
+	for i, c := range t.Columns {
+		if c.Quantity == "" || i >= len(fields) || fields[i] == "" {
+			continue
+		}
+		if !isUnit(fields[i]) {
+			return false
+		}
+		units++
+	}
+	if units == 0 {
+		return false
+	}
+	for i := range t.Columns {
+		if i < len(fields) && t.Columns[i].Unit == "" && isUnit(fields[i]) {
+			t.Columns[i].Unit = fields[i]
+		}
+	}
+	return true
+}
+
+/*
+	Function sets the depth and XY units from the columns and returns the
+	factor each quantity is multiplied by: angles in radians to degrees,
+	TVD in the unit of MD and Y in the unit of X when the two differ
+*/
+func (t *Trajectory) unitScales() (map[string]float64, error) {
+
+	scales := map[string]float64{}
+	unit := func(quantity string) string {
+		for _, c := range t.Columns {
+			if c.Quantity == quantity {
+				return normalizeName(c.Unit)
+			}
+		}
+		return ""
+	}
+
+	for _, quantity := range []string{quantityMD, quantityTVD, quantityX, quantityY} {
+		if u := unit(quantity); u != "" {
+			if _, ok := lengthUnits[u]; !ok {
+				return nil, fmt.Errorf("%s has unit %q, expected m, ft or usft", t.columnName(quantity), u)
+			}
+		}
+	}
+	for _, quantity := range []string{quantityInclination, quantityAzimuth} {
+		scales[quantity] = 1
+		switch u := unit(quantity); {
+		case u == "":
+		case angleUnits[u] == "rad":
+			scales[quantity] = 180 / math.Pi
+		case angleUnits[u] != "deg":
+			return nil, fmt.Errorf("%s has unit %q, expected deg or rad", t.columnName(quantity), u)
+		}
+	}
+
+	// depths without a unit are taken to be metres, like the TNO data sets
+	t.DepthUnit = lengthUnits[unit(quantityMD)]
+	if t.DepthUnit == "" {
+		t.DepthUnit = "m"
+	}
+	scales[quantityMD] = 1
+	scales[quantityTVD] = 1
+	if tvd := lengthUnits[unit(quantityTVD)]; tvd != "" && tvd != t.DepthUnit {
+		scales[quantityTVD] = metresPer[tvd] / metresPer[t.DepthUnit]
+	}
+	t.XYUnit = lengthUnits[unit(quantityX)]
+	if t.XYUnit == "" {
+		t.XYUnit = lengthUnits[unit(quantityY)]
+	}
+	scales[quantityX], scales[quantityY] = 1, 1
+	if y := lengthUnits[unit(quantityY)]; y != "" && y != t.XYUnit {
+		scales[quantityY] = metresPer[y] / metresPer[t.XYUnit]
+	}
+	return scales, nil
+}
+
+// reads the recognized columns of a data row into a station
+func (t *Trajectory) parseRow(fields []string, line int, delimiter rune, scales map[string]float64) (SurveyStation, *TrajectoryRowError) {
+
+	station := SurveyStation{Line: line}
+	for i, c := range t.Columns {
+		if c.Quantity == "" {
+			continue
+		}
+		if i >= len(fields) {
+			return station, &TrajectoryRowError{Line: line, Message: fmt.Sprintf("has %d fields, the header has %d", len(fields), len(t.Columns))}
+		}
+
+		raw := fields[i]
+		if delimiter == ';' {
+			raw = strings.Replace(raw, ",", ".", 1)
+		}
+		if nullValues[strings.ToLower(raw)] {
+			if c.Quantity == quantityMD {
+				return station, &TrajectoryRowError{Line: line, Column: c.Name, Message: "measured depth is missing"}
+			}
+			continue
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsInf(value, 0) || math.IsNaN(value) {
+			return station, &TrajectoryRowError{Line: line, Column: c.Name, Message: fmt.Sprintf("%q is not a number", fields[i])}
+		}
+		value *= scales[c.Quantity]
+
+		switch c.Quantity {
+		case quantityMD:
+			station.MD = value
+		case quantityInclination:
+			if value < 0 || value > 180 {
+				return station, &TrajectoryRowError{Line: line, Column: c.Name, Message: fmt.Sprintf("inclination %g is not between 0 and 180 degrees", value)}
+			}
+			station.Inclination = &value
+		case quantityAzimuth:
+			if value < 0 || value > 360 {
+				return station, &TrajectoryRowError{Line: line, Column: c.Name, Message: fmt.Sprintf("azimuth %g is not between 0 and 360 degrees", value)}
+			}
+			station.Azimuth = &value
+		case quantityTVD:
+			station.TVD = &value
+		case quantityX:
+			station.X = &value
+		case quantityY:
+			station.Y = &value
+		}
+	}
+	return station, nil
+}
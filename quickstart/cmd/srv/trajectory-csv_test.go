@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// a survey shaped like the TNO trajectories, such as data/trajectories/8438.csv:
+// the well in the first column, depths in metres and RD coordinates without units
+const tnoTrajectory = "UWI,MD,TVD,AZIMUTH,INCLINATION,X,Y\n" +
+	"8438,0,0,0,0,85213.2,444519.6\n" +
+	"8438,250,249.99,123.5,0.45,85213.9,444519.1\n" +
+	"8438,500,499.9,131.2,2.1,85218.4,444515.2\n" +
+	"8438,750,749.01,130.8,6.85,85239.1,444497.3\n"
+
+// formats a station for comparison, with the values it lacks as -
+func stationString(s SurveyStation) string {
+	value := func(v *float64) string {
+		if v == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%.4f", *v)
+	}
+	return fmt.Sprintf("line %d md %.4f inc %s azi %s tvd %s x %s y %s",
+		s.Line, s.MD, value(s.Inclination), value(s.Azimuth), value(s.TVD), value(s.X), value(s.Y))
+}
+
+func TestParseTrajectoryCSV(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		csv       string
+		depthUnit string
+		xyUnit    string
+		units     []string // of the columns, when checked
+		stations  []SurveyStation
+		errors    []string // row errors
+		err       string   // of the whole file
+	}{
+		{
+			name:      "TNO survey",
+			csv:       tnoTrajectory,
+			depthUnit: "m",
+			stations: []SurveyStation{
+				{Line: 2, MD: 0, Inclination: float(0), Azimuth: float(0), TVD: float(0), X: float(85213.2), Y: float(444519.6)},
+				{Line: 3, MD: 250, Inclination: float(0.45), Azimuth: float(123.5), TVD: float(249.99), X: float(85213.9), Y: float(444519.1)},
+				{Line: 4, MD: 500, Inclination: float(2.1), Azimuth: float(131.2), TVD: float(499.9), X: float(85218.4), Y: float(444515.2)},
+				{Line: 5, MD: 750, Inclination: float(6.85), Azimuth: float(130.8), TVD: float(749.01), X: float(85239.1), Y: float(444497.3)},
+			},
+		},
+		{
+			name:      "header aliases",
+			csv:       "Measured Depth,Incl,Azimuth_TN,True Vertical Depth,Easting,Northing,Comment\n100,1,2,99.9,10,20,ok\n",
+			depthUnit: "m",
+			stations:  []SurveyStation{{Line: 2, MD: 100, Inclination: float(1), Azimuth: float(2), TVD: float(99.9), X: float(10), Y: float(20)}},
+		},
+		{
+			name:      "first column of a quantity wins",
+			csv:       "MD,DEPTH,INC,AZI\n100,200,1,2\n",
+			depthUnit: "m",
+			stations:  []SurveyStation{{Line: 2, MD: 100, Inclination: float(1), Azimuth: float(2)}},
+		},
+		{
+			name:      "units in brackets",
+			csv:       "MD [ft],Inc(rad),Azi{deg},TVD (m),X[usft],Y[usft]\n1000,0.5,90,304.8,1,2\n",
+			depthUnit: "ft",
+			xyUnit:    "usft",
+			units:     []string{"ft", "rad", "deg", "m", "usft", "usft"},
+			// TVD in metres is taken to the unit of MD, radians to degrees
+			stations: []SurveyStation{{Line: 2, MD: 1000, Inclination: float(0.5 * 180 / math.Pi), Azimuth: float(90), TVD: float(1000), X: float(1), Y: float(2)}},
+		},
+		{
+			name:      "units after an underscore or a space",
+			csv:       "MD_ft,INC_deg,AZI deg,TVD_m\n1000,1,2,3.048\n",
+			depthUnit: "ft",
+			units:     []string{"ft", "deg", "deg", "m"},
+			stations:  []SurveyStation{{Line: 2, MD: 1000, Inclination: float(1), Azimuth: float(2), TVD: float(10)}},
+		},
+		{
+			name:      "units row",
+			csv:       "MD,INC,AZI,X,Y,Remark\nfeet,degrees,,m,m,\n1000,1,2,3,4,x\n",
+			depthUnit: "ft",
+			xyUnit:    "m",
+			units:     []string{"feet", "degrees", "", "m", "m", ""},
+			stations:  []SurveyStation{{Line: 3, MD: 1000, Inclination: float(1), Azimuth: float(2), X: float(3), Y: float(4)}},
+		},
+		{
+			name:      "unknown unit",
+			csv:       "MD (furlong),INC,AZI\n1,2,3\n",
+			err:       `MD (furlong) has unit "furlong", expected m, ft or usft`,
+			depthUnit: "-",
+		},
+		{
+			name:      "semicolons with decimal commas",
+			csv:       "MD;INC;AZI\n0;0;0\n100,5;1,25;45,5\n",
+			depthUnit: "m",
+			stations: []SurveyStation{
+				{Line: 2, MD: 0, Inclination: float(0), Azimuth: float(0)},
+				{Line: 3, MD: 100.5, Inclination: float(1.25), Azimuth: float(45.5)},
+			},
+		},
+		{
+			name:      "tabs",
+			csv:       "MD\tINC\tAZI\n100\t1\t2\n",
+			depthUnit: "m",
+			stations:  []SurveyStation{{Line: 2, MD: 100, Inclination: float(1), Azimuth: float(2)}},
+		},
+		{
+			name:      "white space, preamble, comments and a byte order mark",
+			csv:       "\ufeffWell: 8438\n# exported survey\n  MD   INC   AZI\n// tie-in\n\n 100   1.5   200\n",
+			depthUnit: "m",
+			stations:  []SurveyStation{{Line: 6, MD: 100, Inclination: float(1.5), Azimuth: float(200)}},
+		},
+		{
+			name:      "white space with units in brackets",
+			csv:       "MD (ft)   INC (deg)   AZI [deg]   TVD {m}\n1000   1.5   200   304.8\n",
+			depthUnit: "ft",
+			units:     []string{"ft", "deg", "deg", "m"},
+			stations:  []SurveyStation{{Line: 2, MD: 1000, Inclination: float(1.5), Azimuth: float(200), TVD: float(1000)}},
+		},
+		{
+			name:      "preamble naming a depth",
+			csv:       "Depth reference: KB\nDepth unit: m\nMD,INC,AZI\n100,1,2\n",
+			depthUnit: "m",
+			stations:  []SurveyStation{{Line: 4, MD: 100, Inclination: float(1), Azimuth: float(2)}},
+		},
+		{
+			name:      "Y in another unit than X",
+			csv:       "MD,INC,AZI,X (ft),Y (m)\n100,1,2,1000,304.8\n",
+			depthUnit: "m",
+			xyUnit:    "ft",
+			stations:  []SurveyStation{{Line: 2, MD: 100, Inclination: float(1), Azimuth: float(2), X: float(1000), Y: float(1000)}},
+		},
+		{
+			name:      "quoted fields",
+			csv:       "\"MD\",\"INC\",\"AZI\",\"Remark\"\n\"100\",\"1\",\"2\",\"kick off, 2 deg/30 m\"\n",
+			depthUnit: "m",
+			stations:  []SurveyStation{{Line: 2, MD: 100, Inclination: float(1), Azimuth: float(2)}},
+		},
+		{
+			name:      "null markers",
+			csv:       "MD,INC,AZI,TVD,X,Y\n100,-999.25,,NaN,null,N/A\n200,1,-999,-9999,na,NULL\n",
+			depthUnit: "m",
+			stations:  []SurveyStation{{Line: 2, MD: 100}, {Line: 3, MD: 200, Inclination: float(1)}},
+		},
+		{
+			name:      "row errors with line numbers",
+			csv:       "MD,INC,AZI\n0,0,0\n100,1,1\n150,abc,1\n-999.25,1,1\n200,181,1\n300,1,361\n50,1,1\n400,1\n500,2,3\n",
+			depthUnit: "m",
+			stations: []SurveyStation{
+				{Line: 2, MD: 0, Inclination: float(0), Azimuth: float(0)},
+				{Line: 3, MD: 100, Inclination: float(1), Azimuth: float(1)},
+				{Line: 10, MD: 500, Inclination: float(2), Azimuth: float(3)},
+			},
+			errors: []string{
+				`line 4, INC: "abc" is not a number`,
+				"line 5, MD: measured depth is missing",
+				"line 6, INC: inclination 181 is not between 0 and 180 degrees",
+				"line 7, AZI: azimuth 361 is not between 0 and 360 degrees",
+				"line 8, MD: measured depth 50 is less than 100 of line 3",
+				"line 9: has 2 fields, the header has 3",
+			},
+		},
+		{
+			name:      "no header",
+			csv:       "INC,AZI\nMD,X,Y\n1,2,3\n",
+			depthUnit: "-",
+			err:       "no header with measured depth (MD, DEPTH or Measured Depth) and inclination, azimuth or TVD columns",
+		},
+		{
+			name: "no stations",
+			csv:  "MD,INC,AZI\n# nothing surveyed\n",
+			err:  "no stations after the header",
+		},
+		{
+			name:      "no valid stations",
+			csv:       "MD,INC,AZI\nx,1,2\n",
+			depthUnit: "m",
+			errors:    []string{`line 2, MD: "x" is not a number`},
+			err:       `no valid stations, first error at line 2, MD: "x" is not a number`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			traj, err := parseTrajectoryCSV(strings.NewReader(tt.csv))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("error %v, want %q", err, tt.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if traj == nil {
+				return
+			}
+
+			if traj.DepthUnit != tt.depthUnit || traj.XYUnit != tt.xyUnit {
+				t.Errorf("units %q and %q, want %q and %q", traj.DepthUnit, traj.XYUnit, tt.depthUnit, tt.xyUnit)
+			}
+			for i, unit := range tt.units {
+				if traj.Columns[i].Unit != unit {
+					t.Errorf("column %s has unit %q, want %q", traj.Columns[i].Name, traj.Columns[i].Unit, unit)
+				}
+			}
+
+			if len(traj.Stations) != len(tt.stations) {
+				t.Fatalf("%d stations, want %d: %v", len(traj.Stations), len(tt.stations), traj.Stations)
+			}
+			for i, want := range tt.stations {
+				if got := stationString(traj.Stations[i]); got != stationString(want) {
+					t.Errorf("station %d\n got %s\nwant %s", i, got, stationString(want))
+				}
+			}
+
+			var errors []string
+			for _, e := range traj.Errors {
+				errors = append(errors, e.Error())
+			}
+			if strings.Join(errors, "\n") != strings.Join(tt.errors, "\n") {
+				t.Errorf("row errors\n%s\nwant\n%s", strings.Join(errors, "\n"), strings.Join(tt.errors, "\n"))
+			}
+			if traj.SkippedRows != len(tt.errors) {
+				t.Errorf("%d skipped rows, want %d", traj.SkippedRows, len(tt.errors))
+			}
+		})
+	}
+}
+
+func TestSplitHeaderUnit(t *testing.T) {
+	for _, c := range []struct{ header, name, unit string }{
+		{"MD (m)", "MD", "m"},
+		{"Inc[deg]", "Inc", "deg"},
+		{"TVD_ft", "TVD", "ft"},
+		{"Azimuth deg", "Azimuth", "deg"},
+		{"Measured Depth", "Measured Depth", ""},
+		{"Well_Name", "Well_Name", ""},
+		{"Depth_m", "Depth", "m"},
+	} {
+		if name, unit := splitHeaderUnit(c.header); name != c.name || unit != c.unit {
+			t.Errorf("%q split into %q and %q, want %q and %q", c.header, name, unit, c.name, c.unit)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// course lengths dogleg severity is given over, by depth unit
+var dlsCourseLength = map[string]float64{"m": 30, "ft": 100, "usft": 100}
+
+// errNoSurveyedStations is returned for surveys without both angles, which have no well path
+var errNoSurveyedStations = errors.New("no stations with both inclination and azimuth")
+
+// doglegs below this (radians) are taken as straight lines, where the ratio factor is 1
+const straightDogleg = 1e-9
+
+// WellPath is a well path computed from survey stations with the minimum-curvature method
+type WellPath struct {
+	Method string `json:"method"`
+
+	// surface location the offsets are added to, nil when it is not known,
+	// in XYUnit; X and Y of the stations are in that unit too
+	SurfaceX *float64 `json:"surfaceX,omitempty"`
+	SurfaceY *float64 `json:"surfaceY,omitempty"`
+	XYUnit   string   `json:"xyUnit,omitempty"`
+
+	// azimuth of the vertical section plane, degrees from north
+	VSAzimuth float64 `json:"vsAzimuth"`
+
+	// DLSUnit is the unit of dogleg severity, degrees per 30 m or per 100 of the
+	// depth unit in feet, e.g. deg/100usft
+	DLSUnit  string            `json:"dlsUnit"`
+	Stations []WellPathStation `json:"stations"`
+}
+
+// WellPathStation is a survey station with its computed position
+type WellPathStation struct {
+	MD          float64  `json:"md"`
+	Inclination float64  `json:"inclination"`
+	Azimuth     float64  `json:"azimuth"`
+	TVD         float64  `json:"tvd"`
+	North       float64  `json:"north"` // offset from the surface location
+	East        float64  `json:"east"`
+	X           *float64 `json:"x,omitempty"` // surface location plus offset
+	Y           *float64 `json:"y,omitempty"`
+	DLS         float64  `json:"dls"`
+	VS          float64  `json:"vs"` // vertical section
+}
+
+// wellPathOptions are the inputs of the computation besides the stations
+type wellPathOptions struct {
+	SurfaceX, SurfaceY *float64
+	VSAzimuth          *float64 // degrees, the closure azimuth of the last station when nil
+	DepthUnit          string
+	XYUnit             string // of the surface location, the depth unit when empty
+}
+
+/*
+	Function computes the well path with the minimum-curvature method: two
+	stations are joined by a circular arc, whose dogleg angle B is
+
+	cos B = cos(I2 - I1) - sin I1 sin I2 (1 - cos(A2 - A1))
+
+	and the tangential offsets of both ends are scaled by the ratio factor
+	RF = 2/B tan(B/2). Stations without inclination or azimuth are left
+	out; a survey that does not start at the surface is tied in from a
+	vertical hole at MD 0, unless its first station carries a TVD. The
+	offsets are converted to the unit of the surface location before they
+	are added to it
+*/
+func minimumCurvature(stations []SurveyStation, opts wellPathOptions) (*WellPath, error) {
+
+	var surveyed []SurveyStation
+	for _, s := range stations {
+		if s.Inclination != nil && s.Azimuth != nil {
+			surveyed = append(surveyed, s)
+		}
+	}
+	if len(surveyed) == 0 {
+		return nil, errNoSurveyedStations
+	}
+
+	depthUnit := opts.DepthUnit
+	courseLength, ok := dlsCourseLength[depthUnit]
+	if !ok {
+		depthUnit, courseLength = "m", dlsCourseLength["m"]
+	}
+	xyScale := 1.0
+	if opts.XYUnit != "" && opts.XYUnit != depthUnit {
+		metres, ok := metresPer[opts.XYUnit]
+		if !ok {
+			return nil, fmt.Errorf("cannot convert offsets in %s to the surface location unit %q", depthUnit, opts.XYUnit)
+		}
+		xyScale = metresPer[depthUnit] / metres
+	}
+	path := &WellPath{Method: "minimum curvature", SurfaceX: opts.SurfaceX, SurfaceY: opts.SurfaceY,
+		DLSUnit: fmt.Sprintf("deg/%g%s", courseLength, depthUnit)}
+	if opts.SurfaceX != nil && opts.SurfaceY != nil {
+		path.XYUnit = depthUnit
+		if opts.XYUnit != "" {
+			path.XYUnit = opts.XYUnit
+		}
+	}
+
+	// the tie-in: the first station when it has a TVD, otherwise a vertical hole from the surface
+	first := surveyed[0]
+	prev := WellPathStation{}
+	if first.TVD != nil {
+		prev = WellPathStation{MD: first.MD, Inclination: *first.Inclination, Azimuth: *first.Azimuth, TVD: *first.TVD}
+	}
+
+	for _, s := range surveyed {
+		next := WellPathStation{MD: s.MD, Inclination: *s.Inclination, Azimuth: *s.Azimuth}
+		next.TVD, next.North, next.East, next.DLS = prev.TVD, prev.North, prev.East, 0
+
+		if dMD := s.MD - prev.MD; dMD > 0 {
+			i1, a1 := prev.Inclination*math.Pi/180, prev.Azimuth*math.Pi/180
+			i2, a2 := next.Inclination*math.Pi/180, next.Azimuth*math.Pi/180
+
+			dogleg := math.Acos(math.Max(-1, math.Min(1,
+				math.Cos(i2-i1)-math.Sin(i1)*math.Sin(i2)*(1-math.Cos(a2-a1)))))
+			rf := 1.0
+			if dogleg > straightDogleg {
+				rf = 2 / dogleg * math.Tan(dogleg/2)
+			}
+
+			next.North += dMD / 2 * (math.Sin(i1)*math.Cos(a1) + math.Sin(i2)*math.Cos(a2)) * rf
+			next.East += dMD / 2 * (math.Sin(i1)*math.Sin(a1) + math.Sin(i2)*math.Sin(a2)) * rf
+			next.TVD += dMD / 2 * (math.Cos(i1) + math.Cos(i2)) * rf
+			next.DLS = dogleg * 180 / math.Pi / dMD * courseLength
+		}
+
+		path.Stations = append(path.Stations, next)
+		prev = next
+	}
+
+	// the vertical section is taken along the closure of the last station unless asked otherwise
+	last := path.Stations[len(path.Stations)-1]
+	if opts.VSAzimuth != nil {
+		path.VSAzimuth = math.Mod(math.Mod(*opts.VSAzimuth, 360)+360, 360)
+	} else if last.North != 0 || last.East != 0 {
+		path.VSAzimuth = math.Mod(math.Atan2(last.East, last.North)*180/math.Pi+360, 360)
+	}
+	vsAzimuth := path.VSAzimuth * math.Pi / 180
+
+	for i := range path.Stations {
+		s := &path.Stations[i]
+		s.VS = s.North*math.Cos(vsAzimuth) + s.East*math.Sin(vsAzimuth)
+		if opts.SurfaceX != nil && opts.SurfaceY != nil {
+			x, y := *opts.SurfaceX+s.East*xyScale, *opts.SurfaceY+s.North*xyScale
+			s.X, s.Y = &x, &y
+		}
+	}
+	return path, nil
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// positions are compared to a hundredth of a millimetre or foot
+const wellPathTolerance = 1e-5
+
+func station(md, inc, azi float64) SurveyStation {
+	return SurveyStation{MD: md, Inclination: &inc, Azimuth: &azi}
+}
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func closeTo(got, want float64) bool {
+	return math.Abs(got-want) <= wellPathTolerance*math.Max(1, math.Abs(want))
+}
+
+/*
+	The references are well paths with closed-form positions: minimum
+	curvature joins stations with circular arcs, so on a path that is a
+	circle (a constant build or turn) or a straight line it is exact
+*/
+func TestMinimumCurvatureReferences(t *testing.T) {
+
+	// radius of a 3 deg/100 ft build or turn
+	r := 100 * 180 / (3 * math.Pi)
+
+	tests := []struct {
+		name     string
+		stations []SurveyStation
+		unit     string
+		want     WellPathStation // of the last station
+	}{
+		{
+			name:     "vertical",
+			stations: []SurveyStation{station(0, 0, 0), station(500, 0, 0), station(1234.5, 0, 0)},
+			unit:     "m",
+			want:     WellPathStation{MD: 1234.5, TVD: 1234.5},
+		},
+		{
+			name:     "vertical tied in from the surface",
+			stations: []SurveyStation{station(350, 0, 0)},
+			unit:     "m",
+			want:     WellPathStation{MD: 350, TVD: 350},
+		},
+		{
+			name: "slant at 30 deg towards 060",
+			stations: []SurveyStation{
+				{MD: 0, Inclination: float(30), Azimuth: float(60), TVD: float(0)},
+				station(1000, 30, 60),
+			},
+			unit: "m",
+			// TVD 1000 cos 30, departure 500 split cos 60 north and sin 60 east
+			want: WellPathStation{MD: 1000, Inclination: 30, Azimuth: 60, TVD: 866.0254038, North: 250, East: 433.0127019, VS: 500},
+		},
+		{
+			name:     "3 deg/100 ft build to horizontal towards east",
+			stations: buildStations(0, 3000, 100, 90),
+			unit:     "ft",
+			// quarter circle of radius r: TVD and departure both r
+			want: WellPathStation{MD: 3000, Inclination: 90, Azimuth: 90, TVD: r, East: r, DLS: 3, VS: r},
+		},
+		{
+			name:     "3 deg/100 ft build surveyed at uneven depths",
+			stations: buildStations(0, 3000, 0, 90, 137, 512.4, 1000, 1999.9, 2100, 2950),
+			unit:     "ft",
+			want:     WellPathStation{MD: 3000, Inclination: 90, Azimuth: 90, TVD: r, East: r, DLS: 3, VS: r},
+		},
+		{
+			name: "3 deg/100 ft turn at 90 deg inclination",
+			stations: []SurveyStation{
+				{MD: 5000, Inclination: float(90), Azimuth: float(0), TVD: float(2000)},
+				station(5000+r*math.Pi/4, 90, 45),
+				station(5000+r*math.Pi/2, 90, 90),
+			},
+			unit: "ft",
+			// quarter circle in the horizontal plane
+			want: WellPathStation{MD: 5000 + r*math.Pi/2, Inclination: 90, Azimuth: 90, TVD: 2000, North: r, East: r,
+				DLS: 3, VS: r * math.Sqrt2},
+		},
+		{
+			name:     "3 deg/30 m build in metres",
+			stations: []SurveyStation{station(0, 0, 180), station(30, 3, 180), station(60, 6, 180)},
+			unit:     "m",
+			// arc of radius 30 * 180 / (3 pi) m through 6 degrees, towards south
+			want: WellPathStation{MD: 60, Inclination: 6, Azimuth: 180,
+				TVD: 5400 / (3 * math.Pi) * math.Sin(6*math.Pi/180), North: -5400 / (3 * math.Pi) * (1 - math.Cos(6*math.Pi/180)),
+				DLS: 3, VS: 5400 / (3 * math.Pi) * (1 - math.Cos(6*math.Pi/180))},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := minimumCurvature(tt.stations, wellPathOptions{DepthUnit: tt.unit})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := path.Stations[len(path.Stations)-1]
+			for _, c := range []struct {
+				name      string
+				got, want float64
+			}{
+				{"md", got.MD, tt.want.MD},
+				{"tvd", got.TVD, tt.want.TVD},
+				{"north", got.North, tt.want.North},
+				{"east", got.East, tt.want.East},
+				{"dls", got.DLS, tt.want.DLS},
+				{"vs", got.VS, tt.want.VS},
+			} {
+				if !closeTo(c.got, c.want) {
+					t.Errorf("%s = %.7f, want %.7f", c.name, c.got, c.want)
+				}
+			}
+		})
+	}
+}
+
+// stations on a constant build from vertical at rate deg/100 ft towards the azimuth, at the given depths
+func buildStations(from, to, step, azimuth float64, depths ...float64) []SurveyStation {
+	if step > 0 {
+		for md := from; md <= to; md += step {
+			depths = append(depths, md)
+		}
+	} else {
+		depths = append(depths, to)
+	}
+	stations := make([]SurveyStation, len(depths))
+	for i, md := range depths {
+		stations[i] = station(md, md*3/100, azimuth)
+	}
+	return stations
+}
+
+/*
+	Worked example of the minimum-curvature method in the directional
+	drilling references: from 3500 ft at 15 deg towards 020 to 3600 ft at
+	25 deg towards 045, the dogleg is 12.95 deg and the ratio factor 1.0043,
+	giving 94.01 ft of TVD, 27.22 ft north and 19.45 ft east. The published
+	values are rounded to a hundredth of a foot
+*/
+func TestMinimumCurvatureWorkedExample(t *testing.T) {
+
+	stations := []SurveyStation{
+		{MD: 3500, Inclination: float(15), Azimuth: float(20), TVD: float(0)},
+		station(3600, 25, 45),
+	}
+	path, err := minimumCurvature(stations, wellPathOptions{DepthUnit: "ft"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []WellPathStation{
+		{MD: 3500},
+		// the section is along the closure, so it is the departure sqrt(27.22^2 + 19.45^2)
+		{MD: 3600, TVD: 94.01, North: 27.22, East: 19.45, DLS: 12.95, VS: 33.45},
+	}
+	for i, w := range want {
+		s := path.Stations[i]
+		for _, c := range []struct {
+			name      string
+			got, want float64
+		}{
+			{"tvd", s.TVD, w.TVD},
+			{"north", s.North, w.North},
+			{"east", s.East, w.East},
+			{"dls", s.DLS, w.DLS},
+			{"vs", s.VS, w.VS},
+		} {
+			if math.Abs(c.got-c.want) > 0.005 {
+				t.Errorf("md %g: %s = %.4f, published %.2f", s.MD, c.name, c.got, c.want)
+			}
+		}
+	}
+}
+
+/*
+	Surveying more stations on the arc the method assumes between two
+	stations must not move the end point: the tangent at the extra stations
+	is interpolated on the great circle between the two station tangents
+*/
+func TestMinimumCurvatureSubdividedArc(t *testing.T) {
+
+	tangent := func(inc, azi float64) [3]float64 {
+		i, a := inc*math.Pi/180, azi*math.Pi/180
+		return [3]float64{math.Sin(i) * math.Cos(a), math.Sin(i) * math.Sin(a), math.Cos(i)}
+	}
+
+	for _, c := range []struct{ md1, inc1, azi1, md2, inc2, azi2 float64 }{
+		{1000, 10, 30, 1300, 35, 80},
+		{2000, 60, 350, 2150, 75, 20},
+		{500, 5, 270, 900, 2, 90},
+	} {
+		t1, t2 := tangent(c.inc1, c.azi1), tangent(c.inc2, c.azi2)
+		dogleg := math.Acos(t1[0]*t2[0] + t1[1]*t2[1] + t1[2]*t2[2])
+
+		start := SurveyStation{MD: c.md1, Inclination: float(c.inc1), Azimuth: float(c.azi1), TVD: float(c.md1)}
+		coarse := []SurveyStation{start, station(c.md2, c.inc2, c.azi2)}
+		fine := []SurveyStation{start}
+		for k := 1; k <= 20; k++ {
+			f := float64(k) / 20
+			w1, w2 := math.Sin((1-f)*dogleg)/math.Sin(dogleg), math.Sin(f*dogleg)/math.Sin(dogleg)
+			x, y, z := w1*t1[0]+w2*t2[0], w1*t1[1]+w2*t2[1], w1*t1[2]+w2*t2[2]
+			inc := math.Acos(z) * 180 / math.Pi
+			azi := math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+			fine = append(fine, station(c.md1+f*(c.md2-c.md1), inc, azi))
+		}
+
+		want, err := minimumCurvature(coarse, wellPathOptions{DepthUnit: "ft"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := minimumCurvature(fine, wellPathOptions{DepthUnit: "ft"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, g := want.Stations[1], got.Stations[len(got.Stations)-1]
+		if !closeTo(g.TVD, w.TVD) || !closeTo(g.North, w.North) || !closeTo(g.East, w.East) {
+			t.Errorf("%v: subdivided arc ends at tvd %.6f north %.6f east %.6f, want %.6f %.6f %.6f",
+				c, g.TVD, g.North, g.East, w.TVD, w.North, w.East)
+		}
+		// the dogleg severity is the same all along a circular arc
+		for _, s := range got.Stations[1:] {
+			if !closeTo(s.DLS, w.DLS) {
+				t.Errorf("%v: dls %.6f at md %.1f, want %.6f", c, s.DLS, s.MD, w.DLS)
+			}
+		}
+	}
+}
+
+func TestMinimumCurvatureSurfaceAndSection(t *testing.T) {
+
+	stations := []SurveyStation{station(0, 0, 0), station(1000, 30, 60), station(2000, 45, 60)}
+	path, err := minimumCurvature(stations, wellPathOptions{SurfaceX: float(601234), SurfaceY: float(5934567), VSAzimuth: float(-300), DepthUnit: "m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path.VSAzimuth != 60 {
+		t.Errorf("vs azimuth %g, want 60", path.VSAzimuth)
+	}
+	if path.DLSUnit != "deg/30m" {
+		t.Errorf("dls unit %q, want deg/30m", path.DLSUnit)
+	}
+	for _, s := range path.Stations {
+		if s.X == nil || s.Y == nil || !closeTo(*s.X, 601234+s.East) || !closeTo(*s.Y, 5934567+s.North) {
+			t.Errorf("md %g: x/y not offset from the surface location", s.MD)
+		}
+		// the well stays in the plane of the section, so it is the whole departure
+		if departure := math.Hypot(s.North, s.East); !closeTo(s.VS, departure) {
+			t.Errorf("md %g: vs %.6f, want %.6f", s.MD, s.VS, departure)
+		}
+	}
+
+	if _, err := minimumCurvature([]SurveyStation{{MD: 10, TVD: float(10)}}, wellPathOptions{}); err == nil {
+		t.Error("stations without inclination and azimuth gave a well path")
+	}
+}
+
+// offsets in the depth unit are converted to the unit of the surface location
+func TestMinimumCurvatureSurfaceUnits(t *testing.T) {
+
+	stations := []SurveyStation{station(0, 0, 0), station(1000, 90, 90)}
+	metres, err := minimumCurvature(stations, wellPathOptions{DepthUnit: "m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	east := metres.Stations[1].East
+
+	for _, c := range []struct {
+		depth, xy string
+		want      float64 // x of the last station from a surface x of 1000
+	}{
+		{"m", "", 1000 + east},
+		{"m", "m", 1000 + east},
+		{"m", "ft", 1000 + east/0.3048},
+		{"ft", "m", 1000 + east*0.3048},
+		{"ft", "usft", 1000 + east*0.3048/(1200.0/3937.0)},
+	} {
+		path, err := minimumCurvature(stations, wellPathOptions{SurfaceX: float(1000), SurfaceY: float(0), DepthUnit: c.depth, XYUnit: c.xy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		last := path.Stations[1]
+		if !closeTo(*last.X, c.want) || !closeTo(last.East, east) {
+			t.Errorf("%s offsets on a surface in %q: x %.6f, want %.6f", c.depth, c.xy, *last.X, c.want)
+		}
+		if want := c.xy; (want == "" && path.XYUnit != c.depth) || (want != "" && path.XYUnit != want) {
+			t.Errorf("%s offsets on a surface in %q: xy unit %q", c.depth, c.xy, path.XYUnit)
+		}
+	}
+
+	if _, err := minimumCurvature(stations, wellPathOptions{SurfaceX: float(0), SurfaceY: float(0), DepthUnit: "m", XYUnit: "chain"}); err == nil {
+		t.Error("offsets were added to a surface location in an unknown unit")
+	}
+}
+
+func TestMinimumCurvatureDLSUnit(t *testing.T) {
+
+	stations := []SurveyStation{station(0, 0, 0), station(1000, 90, 90)}
+	for depth, want := range map[string]string{"m": "deg/30m", "ft": "deg/100ft", "usft": "deg/100usft", "": "deg/30m"} {
+		path, err := minimumCurvature(stations, wellPathOptions{DepthUnit: depth})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path.DLSUnit != want {
+			t.Errorf("dogleg severity of depths in %q in %s, want %s", depth, path.DLSUnit, want)
+		}
+	}
+
+	if _, err := minimumCurvature([]SurveyStation{{MD: 100, TVD: float(100)}}, wellPathOptions{}); err != errNoSurveyedStations {
+		t.Errorf("survey without angles: %v", err)
+	}
+}
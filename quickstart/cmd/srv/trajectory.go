@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// trajectory files bigger than this are not parsed
+const maxTrajectorySize = 64 << 20
+
+// trajectoryResponse is the body of /trajectory
+type trajectoryResponse struct {
+	SRN      string `json:"srn"`
+	Filename string `json:"filename"`
+	*Trajectory
+
+	// computed when the stations have inclination and azimuth,
+	// WellPathError tells why it could not be otherwise
+	WellPath      *WellPath `json:"wellPath,omitempty"`
+	WellPathError string    `json:"wellPathError,omitempty"`
+}
+
+/*
+	Trajectory handler fetches the trajectory CSV of an SRN and returns its
+	survey stations as JSON, rows that could not be read are listed under
+	"errors" with their line numbers, or fail the request with ?strict=true.
+	When the stations carry inclination and azimuth the well path is computed
+	with the minimum-curvature method from the surface location in ?x= and
+	?y= (or the first station when it is at MD 0 with X and Y), with the
+	vertical section along ?vs_azimuth= or the closure of the last station.
+	The surface location is in ?xy_unit= (m, ft or usft), the X/Y unit of the
+	file or else its depth unit, and the offsets are converted to it; why a
+	well path could not be computed is told in "wellPathError":
+
+	http://localhost:8080/trajectory?srn=srn:file/csv:6dd13750df8611e9b5df4fa704076d5c:1&x=601234&y=5934567
+*/
+func handleTrajectory(w http.ResponseWriter, r *http.Request) {
+
+	SRN := r.URL.Query().Get("srn")
+	if SRN == "" {
+		writeFetchError(w, http.StatusBadRequest, "srn is required")
+		return
+	}
+	strict, _ := strconv.ParseBool(r.URL.Query().Get("strict"))
+
+	var opts wellPathOptions
+	for param, value := range map[string]**float64{"x": &opts.SurfaceX, "y": &opts.SurfaceY, "vs_azimuth": &opts.VSAzimuth} {
+		if raw := r.URL.Query().Get(param); raw != "" {
+			v, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				writeFetchError(w, http.StatusBadRequest, param+" must be a number")
+				return
+			}
+			*value = &v
+		}
+	}
+	if (opts.SurfaceX == nil) != (opts.SurfaceY == nil) {
+		writeFetchError(w, http.StatusBadRequest, "x and y of the surface location go together")
+		return
+	}
+	if raw := r.URL.Query().Get("xy_unit"); raw != "" {
+		unit, ok := lengthUnits[normalizeName(raw)]
+		if !ok {
+			writeFetchError(w, http.StatusBadRequest, fmt.Sprintf("xy_unit %q is not m, ft or usft", raw))
+			return
+		}
+		opts.XYUnit = unit
+	}
+
+	fileReq := FileRequest{SRNS: []string{SRN}}
+	if err := fileReq.setRegion(r); err != nil {
+		writeFetchError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	data, entry, err := readFile(r.Context(), fileReq, maxTrajectorySize)
+	if err != nil {
+		writeDownloadError(w, SRN, err)
+		return
+	}
+
+	traj, err := parseTrajectoryCSV(bytes.NewReader(data))
+	if err == nil && strict && traj.SkippedRows > 0 {
+		err = traj.Errors[0]
+	}
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, struct {
+			errorResponse
+			Rows []TrajectoryRowError `json:"rows,omitempty"`
+		}{errorResponse{Error: entry.Filename + ": " + err.Error()}, trajectoryErrors(traj)})
+		return
+	}
+
+	response := trajectoryResponse{SRN: SRN, Filename: entry.Filename, Trajectory: traj}
+	if first := traj.Stations[0]; opts.SurfaceX == nil && first.MD == 0 && first.X != nil && first.Y != nil {
+		opts.SurfaceX, opts.SurfaceY = first.X, first.Y
+	}
+	opts.DepthUnit = traj.DepthUnit
+	if opts.XYUnit == "" {
+		opts.XYUnit = traj.XYUnit
+	}
+	path, err := minimumCurvature(traj.Stations, opts)
+	switch {
+	case err == nil:
+		response.WellPath = path
+	case err != errNoSurveyedStations:
+		response.WellPathError = err.Error()
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// returns the row errors of a trajectory that may be nil
+func trajectoryErrors(traj *Trajectory) []TrajectoryRowError {
+	if traj == nil {
+		return nil
+	}
+	return traj.Errors
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"golang.org/x/net/context"
+)
+
+// File service API paths
+const (
+	fileUploadURLPath = "/v2/files/uploadURL"
+	fileMetadataPath  = "/v2/files/metadata"
+)
+
+// uploadLocation is the answer of File service to a request for an upload URL
+type uploadLocation struct {
+	FileID   string       `json:"FileID"`
+	Location uploadTarget `json:"Location"`
+}
+
+/*
+	uploadTarget is where a new file is written: a signed URL on Azure, or a
+	location with temporary credentials just like a Delivery API result when
+	the platform (or a MinIO stand-in) hands out S3 credentials instead
+*/
+type uploadTarget struct {
+	FileLocation
+	FileSource string `json:"FileSource,omitempty"`
+}
+
+// uploadResult is returned for an uploaded and registered file
+type uploadResult struct {
+	ID         string `json:"id"` // record ID or SRN of the new file
+	FileID     string `json:"fileId"`
+	FileSource string `json:"fileSource,omitempty"`
+	Filename   string `json:"filename"`
+	Size       int64  `json:"size"`
+	MD5        string `json:"md5"`
+	SHA256     string `json:"sha256"`
+}
+
+// fileRecord is the metadata record File service registers for an uploaded file
+type fileRecord struct {
+	Kind  string         `json:"kind"`
+	ACL   recordACL      `json:"acl"`
+	Legal recordLegal    `json:"legal"`
+	Data  fileRecordData `json:"data"`
+}
+
+type recordACL struct {
+	Owners  []string `json:"owners"`
+	Viewers []string `json:"viewers"`
+}
+
+type recordLegal struct {
+	LegalTags                  []string `json:"legaltags"`
+	OtherRelevantDataCountries []string `json:"otherRelevantDataCountries"`
+}
+
+type fileRecordData struct {
+	Name              string                `json:"Name"`
+	DatasetProperties fileDatasetProperties `json:"DatasetProperties"`
+}
+
+type fileDatasetProperties struct {
+	FileSourceInfo fileSourceInfo `json:"FileSourceInfo"`
+}
+
+type fileSourceInfo struct {
+	FileSource        string `json:"FileSource"`
+	Name              string `json:"Name"`
+	FileSize          string `json:"FileSize"`
+	Checksum          string `json:"Checksum"`
+	ChecksumAlgorithm string `json:"ChecksumAlgorithm"`
+}
+
+// splits a comma separated list, dropping empty elements; never nil, so it is sent as []
+func splitList(list string) []string {
+	values := []string{}
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+/*
+	Function calls File service with an optional JSON body and returns the
+	response body, anything but a 2xx answer is an error
+*/
+func callFileService(method, apiPath string, body interface{}) ([]byte, error) {
+
+	if fileServiceURL == "" {
+		return nil, errors.New("no File service URL, set OSDU_FILE_SERVICE_URL or OSDU_API_BASE_URL")
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("File service request JSON: %s", buf)
+		reqBody = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(fileServiceURL, "/")+apiPath, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if dataPartitionID != "" {
+		req.Header.Set("data-partition-id", dataPartitionID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("File service %s returned %s", apiPath, resp.Status)
+	}
+	return respBody, nil
+}
+
+// asks File service for a new file ID and the location to write the file to
+func requestUploadLocation() (*uploadLocation, error) {
+
+	body, err := callFileService(http.MethodGet, fileUploadURLPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var loc uploadLocation
+	if err := json.Unmarshal(body, &loc); err != nil {
+		return nil, fmt.Errorf("decoding upload location: %s", err)
+	}
+	if loc.FileID == "" {
+		return nil, errors.New("no FileID in File service response")
+	}
+	return &loc, nil
+}
+
+// writes the file to the upload location with the backend picked for it
+func putUploadFile(ctx context.Context, loc *uploadLocation, body io.Reader, size int64, contentType string) error {
+	backend, err := resolveBackend(loc.Location.FileLocation)
+	if err != nil {
+		return err
+	}
+	log.Printf("Uploading %d bytes of %s to %s storage", size, loc.FileID, backend.Name())
+	return backend.Put(ctx, loc.Location.FileLocation, body, size, contentType)
+}
+
+/*
+	Function registers the metadata of an uploaded file with File service and
+	returns the ID of the new record, or its SRN on platforms that answer
+	with one
+*/
+func registerUploadFile(loc *uploadLocation, name string, size int64, md5 string) (string, error) {
+
+	fileSource := loc.Location.FileSource
+	if fileSource == "" {
+		fileSource = loc.FileID
+	}
+
+	record := fileRecord{
+		Kind:  uploadKind,
+		ACL:   recordACL{Owners: splitList(uploadOwners), Viewers: splitList(uploadViewers)},
+		Legal: recordLegal{LegalTags: splitList(uploadLegalTags), OtherRelevantDataCountries: splitList(uploadCountries)},
+		Data: fileRecordData{
+			Name: name,
+			DatasetProperties: fileDatasetProperties{FileSourceInfo: fileSourceInfo{
+				FileSource:        fileSource,
+				Name:              name,
+				FileSize:          strconv.FormatInt(size, 10),
+				Checksum:          md5,
+				ChecksumAlgorithm: "MD5",
+			}},
+		},
+	}
+
+	body, err := callFileService(http.MethodPost, fileMetadataPath, record)
+	if err != nil {
+		return "", err
+	}
+	for _, key := range []string{"id", "srn", "SRN", "recordIds.0"} {
+		if id := gjson.GetBytes(body, key).String(); id != "" {
+			return id, nil
+		}
+	}
+	return "", errors.New("no record ID in File service response")
+}
+
+/*
+	Function uploads a local file through File service: the file is hashed
+	for its metadata, an upload location is asked for, the file is written
+	to storage and finally registered, which gives the new record ID
+*/
+func uploadFile(ctx context.Context, name string, f *os.File) (*uploadResult, error) {
+
+	verify := newVerifier(nil)
+	size, err := io.Copy(verify, f)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	loc, err := requestUploadLocation()
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := contentTypeFor("", name)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	if err := putUploadFile(ctx, loc, f, size, contentType); err != nil {
+		return nil, err
+	}
+
+	id, err := registerUploadFile(loc, name, size, verify.MD5())
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Registered %s (%d bytes) as %s", name, size, id)
+
+	return &uploadResult{ID: id, FileID: loc.FileID, FileSource: loc.Location.FileSource,
+		Filename: name, Size: size, MD5: verify.MD5(), SHA256: verify.SHA256()}, nil
+}
+
+/*
+	Upload handler takes a trajectory CSV, a LAS file or any other file either
+	as the "file" field of a form or as the raw body named by ?filename=,
+	keeps it in a temporary file and uploads it through File service:
+
+	curl -F file=@8438.csv http://localhost:8080/upload
+	curl -T a05.las "http://localhost:8080/upload?filename=a05.las"
+*/
+func handleUpload(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		writeFetchError(w, http.StatusMethodNotAllowed, "use POST or PUT to upload a file")
+		return
+	}
+
+	name, body := r.URL.Query().Get("filename"), io.Reader(r.Body)
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			writeFetchError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				writeFetchError(w, http.StatusBadRequest, "no file field in the form")
+				return
+			}
+			if part.FormName() == "file" {
+				if part.FileName() != "" {
+					name = part.FileName()
+				}
+				body = part
+				break
+			}
+		}
+	}
+
+	// keep the base name only, clients may send a path
+	name = path.Base(strings.Replace(name, "\\", "/", -1))
+	if name == "." || name == "/" {
+		writeFetchError(w, http.StatusBadRequest, "a file name is required, send a form file or ?filename=")
+		return
+	}
+
+	maxMB, err := strconv.ParseInt(uploadMaxMB, 10, 64)
+	if err != nil || maxMB <= 0 {
+		maxMB = 1024
+	}
+
+	f, err := ioutil.TempFile("", "osdu-upload-*")
+	if err != nil {
+		writeFetchError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	n, err := io.Copy(f, io.LimitReader(body, maxMB<<20+1))
+	if err != nil {
+		writeFetchError(w, http.StatusBadRequest, "reading the upload failed: "+err.Error())
+		return
+	}
+	if n > maxMB<<20 {
+		writeFetchError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file is larger than %d MB", maxMB))
+		return
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		writeFetchError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	result, err := uploadFile(r.Context(), name, f)
+	if err != nil {
+		log.Printf("Upload of %s failed: %s", name, redactSecrets(err.Error()))
+		writeFetchError(w, http.StatusBadGateway, "Upload failed: "+storageErrorReason(err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, result)
+}
+
+/*
+	Upload command sends local files through File service and prints the
+	record ID of each, with the same OSDU_* settings as the server:
+
+	go run ./cmd/srv upload 8438.csv a05.las
+*/
+func runUpload(args []string) error {
+
+	flags := flag.NewFlagSet("upload", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: upload <file>...")
+	}
+
+	for _, name := range flags.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		result, err := uploadFile(context.Background(), path.Base(name), f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, redactSecrets(err.Error()))
+		}
+		fmt.Printf("%s\t%s\n", name, result.ID)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+/*
+	fileServiceStandIn answers File service calls: an upload URL signed for
+	the storage stand-in and the registration of the metadata, which it
+	keeps with the headers it came with
+*/
+type fileServiceStandIn struct {
+	*httptest.Server
+	storage *standIn
+
+	mu       sync.Mutex
+	location string // uploadURL response, a signed URL for storage when empty
+	record   string // metadata response
+	metadata []byte
+	header   http.Header
+}
+
+func newFileServiceStandIn() *fileServiceStandIn {
+	s := &fileServiceStandIn{storage: newStandIn(map[string][]byte{}), record: `{"id":"opendes:dataset--File.Generic:f1"}`}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *fileServiceStandIn) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.header = r.Header
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == fileUploadURLPath:
+		if s.location != "" {
+			w.Write([]byte(s.location))
+			return
+		}
+		json.NewEncoder(w).Encode(uploadLocation{FileID: "f1", Location: uploadTarget{
+			FileLocation: FileLocation{SignedURL: s.storage.URL + "/staging/f1?sig=abc"}, FileSource: "/staging/f1"}})
+	case r.Method == http.MethodPost && r.URL.Path == fileMetadataPath:
+		s.metadata, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(s.record))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *fileServiceStandIn) Close() {
+	s.Server.Close()
+	s.storage.Close()
+}
+
+// points the upload settings at the stand-in and returns a function restoring them
+func useFileService(s *fileServiceStandIn) func() {
+	saved := []string{fileServiceURL, dataPartitionID, uploadKind, uploadOwners, uploadViewers, uploadLegalTags, uploadCountries, uploadMaxMB}
+	fileServiceURL, dataPartitionID = s.URL+"/", "opendes"
+	uploadKind, uploadOwners, uploadViewers = "osdu:wks:dataset--File.Generic:1.0.0", "data.default.owners@opendes.example.com", ""
+	uploadLegalTags, uploadCountries, uploadMaxMB = "opendes-public-usa-dataset-1", "US, NL", "1024"
+	return func() {
+		fileServiceURL, dataPartitionID, uploadKind, uploadOwners = saved[0], saved[1], saved[2], saved[3]
+		uploadViewers, uploadLegalTags, uploadCountries, uploadMaxMB = saved[4], saved[5], saved[6], saved[7]
+	}
+}
+
+func TestRequestUploadLocation(t *testing.T) {
+
+	files := newFileServiceStandIn()
+	defer files.Close()
+	defer useFileService(files)()
+
+	loc, err := requestUploadLocation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.FileID != "f1" || loc.Location.FileSource != "/staging/f1" || !strings.HasSuffix(loc.Location.SignedURL, "/staging/f1?sig=abc") {
+		t.Errorf("location %+v", loc)
+	}
+	if got := files.header.Get("data-partition-id"); got != "opendes" {
+		t.Errorf("data-partition-id %q", got)
+	}
+
+	files.location = `{"Location":{"SignedUrl":"https://x/y"}}`
+	if _, err := requestUploadLocation(); err == nil || err.Error() != "no FileID in File service response" {
+		t.Errorf("response without a file ID: %v", err)
+	}
+
+	fileServiceURL = files.URL + "/missing"
+	if _, err := requestUploadLocation(); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("File service answering 404: %v", err)
+	}
+	fileServiceURL = ""
+	if _, err := requestUploadLocation(); err == nil {
+		t.Error("asked for an upload URL without a File service URL")
+	}
+}
+
+func TestRegisterUploadFile(t *testing.T) {
+
+	files := newFileServiceStandIn()
+	defer files.Close()
+	defer useFileService(files)()
+
+	// the file source is the file ID when File service gives none
+	loc := &uploadLocation{FileID: "f1"}
+	if _, err := registerUploadFile(loc, "8438.csv", 28, "0f2b1a"); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"kind":                             "osdu:wks:dataset--File.Generic:1.0.0",
+		"acl.owners":                       `["data.default.owners@opendes.example.com"]`,
+		"acl.viewers":                      `[]`,
+		"legal.legaltags":                  `["opendes-public-usa-dataset-1"]`,
+		"legal.otherRelevantDataCountries": `["US","NL"]`,
+		"data.Name":                        "8438.csv",
+		"data.DatasetProperties.FileSourceInfo.FileSource":        "f1",
+		"data.DatasetProperties.FileSourceInfo.Name":              "8438.csv",
+		"data.DatasetProperties.FileSourceInfo.FileSize":          "28",
+		"data.DatasetProperties.FileSourceInfo.Checksum":          "0f2b1a",
+		"data.DatasetProperties.FileSourceInfo.ChecksumAlgorithm": "MD5",
+	} {
+		if got := gjson.GetBytes(files.metadata, key); got.String() != want && got.Raw != want {
+			t.Errorf("%s is %s, want %s", key, got.Raw, want)
+		}
+	}
+	if got := files.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type %q", got)
+	}
+
+	// platforms answer with the record ID under different keys
+	for record, want := range map[string]string{
+		`{"id":"opendes:dataset--File.Generic:f1","version":1}`: "opendes:dataset--File.Generic:f1",
+		`{"srn":"srn:file/csv:f1:1"}`:                           "srn:file/csv:f1:1",
+		`{"SRN":"srn:file/csv:f2:1"}`:                           "srn:file/csv:f2:1",
+		`{"recordIds":["opendes:file:f3"],"recordCount":1}`:     "opendes:file:f3",
+		`{"recordCount":0}`:                                     "",
+	} {
+		files.record = record
+		id, err := registerUploadFile(&uploadLocation{FileID: "f1", Location: uploadTarget{FileSource: "/staging/f1"}}, "a05.las", 1, "")
+		if want == "" {
+			if err == nil {
+				t.Errorf("%s: record ID %q", record, id)
+			}
+		} else if err != nil || id != want {
+			t.Errorf("%s: record ID %q, %v, want %q", record, id, err, want)
+		}
+	}
+	if got := gjson.GetBytes(files.metadata, "data.DatasetProperties.FileSourceInfo.FileSource").String(); got != "/staging/f1" {
+		t.Errorf("file source %q", got)
+	}
+}
+
+func TestHandleUpload(t *testing.T) {
+
+	files := newFileServiceStandIn()
+	defer files.Close()
+	defer useFileService(files)()
+
+	const data = "MD,INC,AZI\n0,0,0\n100,1.5,45\n"
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	mw.WriteField("comment", "before the file")
+	part, _ := mw.CreateFormFile("file", `C:\surveys\8438.csv`)
+	part.Write([]byte(data))
+	mw.Close()
+
+	for _, c := range []struct {
+		name, method, url, contentType string
+		body                           []byte
+		status                         int
+		filename                       string
+	}{
+		{"form", http.MethodPost, "/upload", mw.FormDataContentType(), form.Bytes(), http.StatusCreated, "8438.csv"},
+		{"raw body", http.MethodPut, "/upload?filename=logs/a05.las", "", []byte(data), http.StatusCreated, "a05.las"},
+		{"no name", http.MethodPut, "/upload", "", []byte(data), http.StatusBadRequest, ""},
+		{"wrong method", http.MethodGet, "/upload", "", nil, http.StatusMethodNotAllowed, ""},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(c.method, c.url, bytes.NewReader(c.body))
+			if c.contentType != "" {
+				req.Header.Set("Content-Type", c.contentType)
+			}
+			rec := httptest.NewRecorder()
+			handleUpload(rec, req)
+			if rec.Code != c.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, c.status, rec.Body)
+			}
+			if c.status != http.StatusCreated {
+				return
+			}
+
+			var result uploadResult
+			if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			if result.ID != "opendes:dataset--File.Generic:f1" || result.Filename != c.filename || result.Size != int64(len(data)) || result.MD5 != md5Hex(data) {
+				t.Errorf("result %+v", result)
+			}
+			if got := string(files.storage.objects["/staging/f1"]); got != data {
+				t.Errorf("storage has %q", got)
+			}
+			if got := gjson.GetBytes(files.metadata, "data.DatasetProperties.FileSourceInfo.Checksum").String(); got != md5Hex(data) {
+				t.Errorf("registered checksum %q", got)
+			}
+		})
+	}
+
+	// a body over the limit is refused before anything is sent to File service
+	uploadMaxMB = "1"
+	files.metadata = nil
+	rec := httptest.NewRecorder()
+	handleUpload(rec, httptest.NewRequest(http.MethodPut, "/upload?filename=big.las", bytes.NewReader(make([]byte, 1<<20+1))))
+	if rec.Code != http.StatusRequestEntityTooLarge || files.metadata != nil {
+		t.Errorf("status %d for a file over the limit: %s", rec.Code, rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	handleUpload(rec, httptest.NewRequest(http.MethodPut, "/upload?filename=fits.las", bytes.NewReader(make([]byte, 1<<20))))
+	if rec.Code != http.StatusCreated {
+		t.Errorf("status %d for a file of the limit: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRunUpload(t *testing.T) {
+
+	files := newFileServiceStandIn()
+	defer files.Close()
+	defer useFileService(files)()
+
+	dir, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "a05.las")
+	if err := ioutil.WriteFile(name, []byte("~V\nVERS. 2.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runUpload([]string{name}); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(files.storage.objects["/staging/f1"]); got != "~V\nVERS. 2.0\n" {
+		t.Errorf("storage has %q", got)
+	}
+	if got := gjson.GetBytes(files.metadata, "data.Name").String(); got != "a05.las" {
+		t.Errorf("registered as %q", got)
+	}
+
+	if err := runUpload(nil); err == nil {
+		t.Error("ran without files")
+	}
+	if err := runUpload([]string{filepath.Join(dir, "missing.las")}); err == nil {
+		t.Error("uploaded a missing file")
+	}
+	files.record = `{}`
+	if err := runUpload([]string{name}); err == nil || !strings.Contains(err.Error(), "no record ID") {
+		t.Errorf("registration without a record ID: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+/*
+	Package redact scrubs credentials out of log lines and error messages:
+	signatures of SAS, presigned S3 and GCS URLs, bearer and basic
+	authorization, OAuth client secrets and tokens, and JWTs. The server and
+	the fetch example both log through it, so they hide the same secrets
+*/
+package redact
+
+import (
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Redacted is what secrets are replaced with
+const Redacted = "REDACTED"
+
+// pattern finds one kind of secret, the replacement keeps its name and drops its value
+type pattern struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// names of OAuth, OSDU and cloud credentials wherever they show up as a field
+const secretNames = `client_secret|clientsecret|refresh_token|refreshtoken|id_token|idtoken|access_token|accesstoken|` +
+	`secretaccesskey|sessiontoken|sas|password`
+
+var patterns = []pattern{
+	// query parameters of signed storage URLs that grant access (SAS, presigned S3 and GCS URLs)
+	{regexp.MustCompile(`(?i)\b(sig|x-amz-signature|x-amz-credential|x-amz-security-token|x-goog-signature|x-goog-credential)=[^&\s"'<>]+`),
+		"${1}=" + Redacted},
+	// Authorization: Bearer <token> or Basic <credentials> as a header or a field
+	{regexp.MustCompile(`(?i)\b(authorization"?\s*[:=]\s*"?(?:bearer|basic)\s+)[^\s"']+`), "${1}" + Redacted},
+	// a bearer token on its own, long enough not to be prose
+	{regexp.MustCompile(`(?i)\b(bearer\s+)[A-Za-z0-9\-._~+/]{16,}=*`), "${1}" + Redacted},
+	// "refresh_token": "...", a JSON field with a string value
+	{regexp.MustCompile(`(?i)("(?:` + secretNames + `)"\s*:\s*)"(?:[^"\\]|\\.)*"`), `${1}"` + Redacted + `"`},
+	// refresh_token=... in a form body or query string
+	{regexp.MustCompile(`(?i)\b((?:` + secretNames + `)=)[^&\s"'<>]+`), "${1}" + Redacted},
+	// RefreshToken:... in structs printed with %+v, like an oauth2.Token; plain %v
+	// leaves out the field names, so tokens must not be logged that way
+	{regexp.MustCompile(`(?i)\b((?:` + secretNames + `):)[^\s,}"]+`), "${1}" + Redacted},
+	// a JWT anywhere else, e.g. an id_token passed around on its own
+	{regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), Redacted},
+}
+
+/*
+	String replaces SAS signatures, presigned URL credentials, bearer
+	tokens, client secrets, refresh, access and ID tokens in s with REDACTED,
+	and each of the verbatim secrets, such as a configured client secret,
+	wherever it appears; those shorter than 4 bytes are left alone
+*/
+func String(s string, verbatim ...string) string {
+	for _, p := range patterns {
+		s = p.re.ReplaceAllString(s, p.replacement)
+	}
+	for _, secret := range verbatim {
+		if len(secret) >= 4 {
+			s = strings.Replace(s, secret, Redacted, -1)
+		}
+	}
+	return s
+}
+
+/*
+	Writer scrubs secrets from everything written through it, to be the
+	output of the standard logger, which writes each line in one call
+*/
+type Writer struct {
+	W        io.Writer
+	Verbatim []string
+}
+
+func (r *Writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.W, String(string(p), r.Verbatim...)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,41 @@
+package redact
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	for _, c := range []struct {
+		in, want string
+		verbatim []string
+	}{
+		{in: "GET https://tno.blob.core.windows.net/tno/8438.csv?sv=2019-02-02&sig=Zm9vYmFy%3D&sp=r",
+			want: "GET https://tno.blob.core.windows.net/tno/8438.csv?sv=2019-02-02&sig=REDACTED&sp=r"},
+		{in: "https://b.s3.amazonaws.com/k?X-Amz-Expires=900&X-Amz-Signature=aeeed9bb",
+			want: "https://b.s3.amazonaws.com/k?X-Amz-Expires=900&X-Amz-Signature=REDACTED"},
+		{in: "Authorization: Bearer abc.def", want: "Authorization: Bearer REDACTED"},
+		{in: `{"refresh_token": "tGzv3JOkF0XG5Qx2TlKWIA", "expires_in": 3600}`, want: `{"refresh_token": "REDACTED", "expires_in": 3600}`},
+		{in: "client_id=app&client_secret=hunter2", want: "client_id=app&client_secret=REDACTED"},
+		{in: "token eyJhbGciOiJSUzI1NiJ9.eyJzdWIiOiJ1c2VyIn0.c2ln expired", want: "token REDACTED expired"},
+		{in: "login with s3cr3t-client-value failed", verbatim: []string{"s3cr3t-client-value"}, want: "login with REDACTED failed"},
+		// short or empty secrets would scrub ordinary text
+		{in: "abc failed", verbatim: []string{"", "abc"}, want: "abc failed"},
+		{in: "nothing secret at 8438.csv", want: "nothing secret at 8438.csv"},
+	} {
+		if got := String(c.in, c.verbatim...); got != c.want {
+			t.Errorf("String(%q)\n got %s\nwant %s", c.in, got, c.want)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&Writer{W: &buf, Verbatim: []string{"s3cr3t-client-value"}}, "", 0)
+	logger.Printf("Error downloading Blob: GET https://tno.blob.core.windows.net/tno/8438.csv?sv=2019-02-02&sig=Zm9vYmFy%%3D with s3cr3t-client-value")
+	if got := buf.String(); strings.Contains(got, "Zm9vYmFy") || strings.Contains(got, "s3cr3t") || !strings.HasSuffix(got, "sig=REDACTED with REDACTED\n") {
+		t.Errorf("logged %q", got)
+	}
+}